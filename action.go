@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	foundation "github.com/estafette/estafette-foundation"
+)
+
+// action is implemented by every value supported by the action parameter
+type action interface {
+	// Name returns the value for the action parameter to select this action
+	Name() string
+	// Description returns a single line describing the action for help text and docs
+	Description() string
+	// RequiredParams returns the yaml keys of the parameters that need to have a value after defaults are applied
+	RequiredParams() []string
+	// OptionalParams returns the yaml keys of the parameters used by the action if set
+	OptionalParams() []string
+	// Run executes the action with the parameters after defaults are applied, using the runner for all external commands
+	Run(ctx context.Context, runner CommandRunner, params params) error
+}
+
+// actions contains all actions supported by this extension, in the order they're usually used in a pipeline
+var actions = newActionRegistry(
+	lintAction{},
+	packageAction{},
+	testAction{},
+	publishAction{},
+	purgeAction{},
+	diffAction{},
+	installAction{},
+	uninstallAction{},
+)
+
+type actionRegistry struct {
+	actions []action
+}
+
+func newActionRegistry(actions ...action) *actionRegistry {
+	r := &actionRegistry{}
+	for _, a := range actions {
+		r.Register(a)
+	}
+	return r
+}
+
+// Register adds an action to the registry; it panics if an action with the same name is already registered
+func (r *actionRegistry) Register(a action) {
+	if _, exists := r.Get(a.Name()); exists {
+		panic(fmt.Sprintf("action %v is registered more than once", a.Name()))
+	}
+	r.actions = append(r.actions, a)
+}
+
+// Get returns the action with the given name if it's registered
+func (r *actionRegistry) Get(name string) (action, bool) {
+	for _, a := range r.actions {
+		if a.Name() == name {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+// Names returns the names of all registered actions in order of registration
+func (r *actionRegistry) Names() (names []string) {
+	for _, a := range r.actions {
+		names = append(names, a.Name())
+	}
+	return
+}
+
+// SupportedList returns the quoted names of all actions for use in messages, like 'lint', 'package' or 'test'
+func (r *actionRegistry) SupportedList() string {
+	return r.formatNames("'%v'")
+}
+
+func (r *actionRegistry) formatNames(format string) string {
+	quoted := []string{}
+	for _, name := range r.Names() {
+		quoted = append(quoted, fmt.Sprintf(format, name))
+	}
+	if len(quoted) < 2 {
+		return strings.Join(quoted, "")
+	}
+	return fmt.Sprintf("%v or %v", strings.Join(quoted[:len(quoted)-1], ", "), quoted[len(quoted)-1])
+}
+
+// UnsupportedError returns the error for an action name that isn't registered
+func (r *actionRegistry) UnsupportedError(name string) error {
+	return fmt.Errorf("action '%v' is not supported; please use action parameter value %v", name, r.SupportedList())
+}
+
+// HelpText returns the description of the extension followed by the supported actions and their parameters
+func (r *actionRegistry) HelpText() string {
+	var sb strings.Builder
+	sb.WriteString("Estafette extension for linting, packaging, testing and adding Helm charts to repositories.\n\nSupported actions:\n")
+	for _, a := range r.actions {
+		sb.WriteString(fmt.Sprintf("  %-10v %v\n", a.Name(), a.Description()))
+		if len(a.RequiredParams()) > 0 {
+			sb.WriteString(fmt.Sprintf("  %-10v required: %v\n", "", strings.Join(a.RequiredParams(), ", ")))
+		}
+		if len(a.OptionalParams()) > 0 {
+			sb.WriteString(fmt.Sprintf("  %-10v optional: %v\n", "", strings.Join(a.OptionalParams(), ", ")))
+		}
+	}
+	return sb.String()
+}
+
+// ParameterTable returns the markdown table documenting all parameters, as used in the README
+func (r *actionRegistry) ParameterTable() string {
+
+	paramTypes := map[string]string{}
+	t := reflect.TypeOf(params{})
+	for i := 0; i < t.NumField(); i++ {
+		paramTypes[yamlKey(t.Field(i))] = t.Field(i).Type.Kind().String()
+		if t.Field(i).Type.Kind() == reflect.Slice {
+			paramTypes[yamlKey(t.Field(i))] = "list"
+		}
+	}
+
+	rows := [][]string{{"Parameter", "Type", "Actions", "Values"}}
+	for _, pd := range paramDescriptions {
+		usedBy := []string{}
+		for _, a := range r.actions {
+			if foundation.StringArrayContains(a.RequiredParams(), pd.Key) || foundation.StringArrayContains(a.OptionalParams(), pd.Key) {
+				usedBy = append(usedBy, a.Name())
+			}
+		}
+		description := pd.Description
+		if foundation.StringArrayContains(globalParamKeys, pd.Key) {
+			usedBy = []string{"all"}
+			if strings.Contains(description, "%v") {
+				description = fmt.Sprintf(description, r.formatNames("`%v`"))
+			}
+		}
+		rows = append(rows, []string{fmt.Sprintf("`%v`", pd.Key), paramTypes[pd.Key], strings.Join(usedBy, ", "), description})
+	}
+
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			if len(cell) > widths[i] {
+				widths[i] = len(cell)
+			}
+		}
+	}
+
+	var sb strings.Builder
+	writeRow := func(row []string) {
+		for i, cell := range row {
+			sb.WriteString(fmt.Sprintf("| %-*v ", widths[i], cell))
+		}
+		sb.WriteString("|\n")
+	}
+	writeRow(rows[0])
+	separator := []string{}
+	for _, w := range widths {
+		separator = append(separator, strings.Repeat("-", w))
+	}
+	writeRow(separator)
+	for _, row := range rows[1:] {
+		writeRow(row)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAction struct {
+	name string
+	err  error
+	runs *[]string
+}
+
+func (a fakeAction) Name() string {
+	return a.name
+}
+
+func (a fakeAction) Description() string {
+	return "Does nothing"
+}
+
+func (a fakeAction) RequiredParams() []string {
+	return []string{"chart"}
+}
+
+func (a fakeAction) OptionalParams() []string {
+	return nil
+}
+
+func (a fakeAction) Run(ctx context.Context, runner CommandRunner, params params) error {
+	if a.runs != nil {
+		*a.runs = append(*a.runs, a.name)
+	}
+	return a.err
+}
+
+func TestActionRegistry(t *testing.T) {
+	t.Run("GetReturnsRegisteredAction", func(t *testing.T) {
+
+		registry := newActionRegistry(fakeAction{name: "first"}, fakeAction{name: "second"})
+
+		// act
+		a, ok := registry.Get("second")
+
+		if assert.True(t, ok) {
+			assert.Equal(t, "second", a.Name())
+		}
+	})
+
+	t.Run("GetReturnsFalseForUnknownAction", func(t *testing.T) {
+
+		registry := newActionRegistry(fakeAction{name: "first"})
+
+		// act
+		_, ok := registry.Get("second")
+
+		assert.False(t, ok)
+	})
+
+	t.Run("RegisterPanicsForDuplicateName", func(t *testing.T) {
+
+		registry := newActionRegistry(fakeAction{name: "first"})
+
+		assert.Panics(t, func() {
+			// act
+			registry.Register(fakeAction{name: "first"})
+		})
+	})
+
+	t.Run("UnsupportedErrorListsAllActionsInOrder", func(t *testing.T) {
+
+		registry := newActionRegistry(fakeAction{name: "first"}, fakeAction{name: "second"}, fakeAction{name: "third"})
+
+		// act
+		err := registry.UnsupportedError("fourth")
+
+		assert.Equal(t, "action 'fourth' is not supported; please use action parameter value 'first', 'second' or 'third'", err.Error())
+	})
+
+	t.Run("SupportedListIncludesUninstall", func(t *testing.T) {
+
+		// act
+		list := actions.SupportedList()
+
+		assert.Contains(t, list, "'uninstall'")
+	})
+
+	t.Run("HelpTextListsActionsWithTheirParameters", func(t *testing.T) {
+
+		registry := newActionRegistry(fakeAction{name: "first"})
+
+		// act
+		helpText := registry.HelpText()
+
+		assert.Contains(t, helpText, "  first      Does nothing\n")
+		assert.Contains(t, helpText, "required: chart\n")
+	})
+
+	t.Run("ParameterTableDocumentsAllParameters", func(t *testing.T) {
+
+		// act
+		table := actions.ParameterTable()
+
+		for _, key := range knownParamKeys() {
+			assert.Contains(t, table, "| `"+key+"` ")
+		}
+	})
+
+	t.Run("ReadmeContainsGeneratedParameterTable", func(t *testing.T) {
+
+		readme, err := ioutil.ReadFile("README.md")
+		assert.Nil(t, err)
+
+		// act
+		table := actions.ParameterTable()
+
+		assert.True(t, strings.Contains(string(readme), table), "README.md is out of date; update its parameter table with the output of --print-parameters-table")
+	})
+
+	t.Run("RequiredAndOptionalParamsAreKnownKeys", func(t *testing.T) {
+
+		knownKeys := knownParamKeys()
+
+		for _, name := range actions.Names() {
+			a, _ := actions.Get(name)
+
+			// act
+			keys := append(a.RequiredParams(), a.OptionalParams()...)
+
+			for _, key := range keys {
+				assert.Contains(t, knownKeys, key, "action %v", name)
+			}
+		}
+	})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// metadataServerURL is the gce metadata server, or the host set in $GCE_METADATA_HOST like the google client libraries use
+	metadataServerURL = defaultMetadataServerURL()
+	stsTokenURL       = "https://sts.googleapis.com/v1/token"
+	iamCredentialsURL = "https://iamcredentials.googleapis.com"
+
+	// accessTokenPath is where the short-lived access token of a credential without keyfile is stored for gcloud
+	accessTokenPath = "/access-token"
+
+	googleAuthClient = &http.Client{Timeout: 30 * time.Second}
+)
+
+const cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+func defaultMetadataServerURL() string {
+	if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+		return "http://" + host
+	}
+	return "http://metadata.google.internal"
+}
+
+// googleAccessToken is a short-lived access token with the service account it belongs to, if known
+type googleAccessToken struct {
+	Token   string
+	Account string
+}
+
+// initAmbientCredential authenticates gcloud, the helm gcs plugin and sops with a short-lived access token for a credential without keyfile, instead of activating a service account with a long-lived keyfile
+func initAmbientCredential(ctx context.Context, runner CommandRunner, params params, credential *GKECredentials) error {
+	account := ""
+	if params.DryRun {
+		log.Info().Msgf("Dry run: skipping requesting access token for credential %v...", params.Credentials)
+	} else {
+		log.Info().Msgf("Requesting short-lived access token for credential %v...", params.Credentials)
+		token, err := requestAmbientAccessToken(ctx, credential.Name, credential.AdditionalProperties)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(accessTokenPath, []byte(token.Token), 0600)
+		if err != nil {
+			return fmt.Errorf("failed writing access token: %w", err)
+		}
+		// the helm gcs plugin and sops pick up the token from the environment
+		os.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", token.Token)
+		account = token.Account
+	}
+
+	log.Info().Msg("Authenticating to google cloud with access token")
+	err := runner.RunCommandWithArgs(ctx, "gcloud", []string{"config", "set", "auth/access_token_file", accessTokenPath})
+	if err != nil {
+		return err
+	}
+
+	if account != "" {
+		log.Info().Msgf("Setting gcloud account to %v", account)
+		return runner.RunCommandWithArgs(ctx, "gcloud", []string{"config", "set", "account", account})
+	}
+	return nil
+}
+
+// requestAmbientAccessToken gets an access token from the workload identity federation token file if the credential has one, or from the metadata server otherwise; the token is exchanged for a token of impersonateServiceAccount if set
+func requestAmbientAccessToken(ctx context.Context, name string, properties GKECredentialAdditionalProperties) (token *googleAccessToken, err error) {
+	if properties.TokenFile != "" {
+		if properties.WorkloadIdentityProvider == "" {
+			return nil, fmt.Errorf("credential %v has a tokenFile but no workloadIdentityProvider to exchange it with", name)
+		}
+		token, err = exchangeFederatedToken(ctx, properties.WorkloadIdentityProvider, properties.TokenFile)
+	} else {
+		token, err = requestMetadataServerToken(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	secretMasker.AddSecrets(token.Token)
+
+	if properties.ImpersonateServiceAccount != "" {
+		token, err = impersonateServiceAccount(ctx, token, properties.ImpersonateServiceAccount)
+		if err != nil {
+			return nil, err
+		}
+		secretMasker.AddSecrets(token.Token)
+	}
+
+	return token, nil
+}
+
+// requestMetadataServerToken gets an access token for the service account of the gce instance or gke workload identity
+func requestMetadataServerToken(ctx context.Context) (*googleAccessToken, error) {
+	var response struct {
+		AccessToken string `json:"access_token"`
+	}
+	err := doGoogleAuthRequest(ctx, http.MethodGet, metadataServerURL+"/computeMetadata/v1/instance/service-accounts/default/token", nil, map[string]string{"Metadata-Flavor": "Google"}, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed requesting access token from metadata server: %w", err)
+	}
+
+	var email bytes.Buffer
+	err = doGoogleAuthRequest(ctx, http.MethodGet, metadataServerURL+"/computeMetadata/v1/instance/service-accounts/default/email", nil, map[string]string{"Metadata-Flavor": "Google"}, &email)
+	if err != nil {
+		return nil, fmt.Errorf("failed requesting service account email from metadata server: %w", err)
+	}
+
+	return &googleAccessToken{Token: response.AccessToken, Account: strings.TrimSpace(email.String())}, nil
+}
+
+// exchangeFederatedToken exchanges the token in the file, like an oidc token of the ci system, for a federated access token with the security token service
+func exchangeFederatedToken(ctx context.Context, workloadIdentityProvider, tokenFile string) (*googleAccessToken, error) {
+	subjectToken, err := ioutil.ReadFile(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading workload identity token file %v: %w", tokenFile, err)
+	}
+
+	form := url.Values{
+		"grant_type":           {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"audience":             {"//iam.googleapis.com/" + strings.TrimPrefix(workloadIdentityProvider, "//iam.googleapis.com/")},
+		"scope":                {cloudPlatformScope},
+		"requested_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"subject_token":        {strings.TrimSpace(string(subjectToken))},
+		"subject_token_type":   {"urn:ietf:params:oauth:token-type:jwt"},
+	}
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+	}
+	err = doGoogleAuthRequest(ctx, http.MethodPost, stsTokenURL, strings.NewReader(form.Encode()), map[string]string{"Content-Type": "application/x-www-form-urlencoded"}, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed exchanging workload identity token: %w", err)
+	}
+
+	return &googleAccessToken{Token: response.AccessToken}, nil
+}
+
+// impersonateServiceAccount exchanges the token for a short-lived access token of the service account, which the account of the token needs the service account token creator role on
+func impersonateServiceAccount(ctx context.Context, token *googleAccessToken, serviceAccount string) (*googleAccessToken, error) {
+	body, _ := json.Marshal(map[string]interface{}{
+		"scope":    []string{cloudPlatformScope},
+		"lifetime": "3600s",
+	})
+
+	var response struct {
+		AccessToken string `json:"accessToken"`
+	}
+	endpoint := fmt.Sprintf("%v/v1/projects/-/serviceAccounts/%v:generateAccessToken", iamCredentialsURL, url.PathEscape(serviceAccount))
+	err := doGoogleAuthRequest(ctx, http.MethodPost, endpoint, bytes.NewReader(body), map[string]string{"Authorization": "Bearer " + token.Token, "Content-Type": "application/json"}, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed impersonating service account %v: %w", serviceAccount, err)
+	}
+
+	return &googleAccessToken{Token: response.AccessToken, Account: serviceAccount}, nil
+}
+
+// doGoogleAuthRequest sends the request and unmarshals the json response into response, or copies the response as is if it's a buffer
+func doGoogleAuthRequest(ctx context.Context, method, endpoint string, body io.Reader, headers map[string]string, response interface{}) error {
+	request, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	for key, value := range headers {
+		request.Header.Set(key, value)
+	}
+
+	resp, err := googleAuthClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v %v returned status code %v: %v", method, endpoint, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+
+	if buffer, ok := response.(*bytes.Buffer); ok {
+		_, err = buffer.Write(data)
+		return err
+	}
+	return json.Unmarshal(data, response)
+}
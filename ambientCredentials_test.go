@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// metadataServerStandIn serves the gce metadata server, security token service and iam credentials endpoints used for ambient credentials, and records the requests it received
+type metadataServerStandIn struct {
+	requests []*http.Request
+	forms    []map[string][]string
+}
+
+func newMetadataServerStandIn(t *testing.T) *metadataServerStandIn {
+	standIn := &metadataServerStandIn{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		standIn.requests = append(standIn.requests, r)
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			if r.Header.Get("Metadata-Flavor") != "Google" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"ya29.metadata-token","expires_in":3599,"token_type":"Bearer"}`)
+		case "/computeMetadata/v1/instance/service-accounts/default/email":
+			fmt.Fprint(w, "builder@my-project.iam.gserviceaccount.com")
+		case "/v1/token":
+			_ = r.ParseForm()
+			standIn.forms = append(standIn.forms, r.PostForm)
+			fmt.Fprint(w, `{"access_token":"ya29.federated-token","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer"}`)
+		case "/v1/projects/-/serviceAccounts/deployer@my-project.iam.gserviceaccount.com:generateAccessToken":
+			if r.Header.Get("Authorization") != "Bearer ya29.metadata-token" && r.Header.Get("Authorization") != "Bearer ya29.federated-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var body map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			fmt.Fprintf(w, `{"accessToken":"ya29.impersonated-token-%v","expireTime":"2023-06-30T13:00:00Z"}`, body["lifetime"])
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	originalMetadataServerURL, originalStsTokenURL, originalIamCredentialsURL := metadataServerURL, stsTokenURL, iamCredentialsURL
+	metadataServerURL, stsTokenURL, iamCredentialsURL = server.URL, server.URL+"/v1/token", server.URL
+	t.Cleanup(func() {
+		metadataServerURL, stsTokenURL, iamCredentialsURL = originalMetadataServerURL, originalStsTokenURL, originalIamCredentialsURL
+	})
+
+	return standIn
+}
+
+// withKeylessCredentials injects a kubernetes-engine credential named gke-production without keyfile and stores the access token in a temporary directory
+func withKeylessCredentials(t *testing.T, additionalProperties string) {
+	dir := t.TempDir()
+
+	credentialsFile := filepath.Join(dir, "kubernetes_engine.json")
+	credentials := fmt.Sprintf(`[{"name":"gke-production","type":"kubernetes-engine","additionalProperties":%v}]`, additionalProperties)
+	if err := ioutil.WriteFile(credentialsFile, []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	originalCredentialsPath, originalAccessTokenPath := *credentialsPath, accessTokenPath
+	*credentialsPath = credentialsFile
+	accessTokenPath = filepath.Join(dir, "access-token")
+	t.Cleanup(func() {
+		*credentialsPath = originalCredentialsPath
+		accessTokenPath = originalAccessTokenPath
+		initializedCredential = ""
+	})
+
+	// the token is exported for the helm gcs plugin and sops; restore it after the test
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "")
+	withSecretMasker(t)
+}
+
+func TestInitAmbientCredential(t *testing.T) {
+	t.Run("AuthenticatesWithTokenFromMetadataServerIfCredentialHasNoKeyfile", func(t *testing.T) {
+
+		standIn := newMetadataServerStandIn(t)
+		withKeylessCredentials(t, `{"project":"my-project","cluster":"my-cluster","zone":"europe-west1-c"}`)
+		runner := &fakeCommandRunner{}
+
+		// act
+		_, err := initCredential(context.Background(), runner, params{Credentials: "gke-production"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"gcloud config set auth/access_token_file " + accessTokenPath,
+			"gcloud config set account builder@my-project.iam.gserviceaccount.com",
+		}, runner.commandLines())
+		data, _ := ioutil.ReadFile(accessTokenPath)
+		assert.Equal(t, "ya29.metadata-token", string(data))
+		assert.Equal(t, 2, len(standIn.requests))
+		assert.Equal(t, "token ***", secretMasker.MaskString("token ya29.metadata-token"))
+	})
+
+	t.Run("ImpersonatesTargetServiceAccountWithShortLivedToken", func(t *testing.T) {
+
+		newMetadataServerStandIn(t)
+		withKeylessCredentials(t, `{"project":"my-project","cluster":"my-cluster","zone":"europe-west1-c","impersonateServiceAccount":"deployer@my-project.iam.gserviceaccount.com"}`)
+		runner := &fakeCommandRunner{}
+
+		// act
+		_, err := initCredential(context.Background(), runner, params{Credentials: "gke-production"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "gcloud config set account deployer@my-project.iam.gserviceaccount.com", runner.commandLines()[1])
+		data, _ := ioutil.ReadFile(accessTokenPath)
+		assert.Equal(t, "ya29.impersonated-token-3600s", string(data))
+	})
+
+	t.Run("ExchangesWorkloadIdentityTokenFileBeforeImpersonating", func(t *testing.T) {
+
+		standIn := newMetadataServerStandIn(t)
+		tokenFile := filepath.Join(t.TempDir(), "oidc-token")
+		_ = ioutil.WriteFile(tokenFile, []byte("eyJhbGciOiJSUzI1NiJ9.e30.c2ln\n"), 0600)
+		withKeylessCredentials(t, fmt.Sprintf(`{"project":"my-project","cluster":"my-cluster","zone":"europe-west1-c","workloadIdentityProvider":"projects/123/locations/global/workloadIdentityPools/estafette/providers/ci","tokenFile":%q,"impersonateServiceAccount":"deployer@my-project.iam.gserviceaccount.com"}`, tokenFile))
+		runner := &fakeCommandRunner{}
+
+		// act
+		_, err := initCredential(context.Background(), runner, params{Credentials: "gke-production"})
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(standIn.forms)) {
+			assert.Equal(t, "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/estafette/providers/ci", standIn.forms[0]["audience"][0])
+			assert.Equal(t, "eyJhbGciOiJSUzI1NiJ9.e30.c2ln", standIn.forms[0]["subject_token"][0])
+		}
+		data, _ := ioutil.ReadFile(accessTokenPath)
+		assert.Equal(t, "ya29.impersonated-token-3600s", string(data))
+	})
+
+	t.Run("OnlyConfiguresAccessTokenFileInDryRun", func(t *testing.T) {
+
+		standIn := newMetadataServerStandIn(t)
+		withKeylessCredentials(t, `{"project":"my-project","cluster":"my-cluster","zone":"europe-west1-c"}`)
+		runner := &fakeCommandRunner{}
+
+		// act
+		_, err := initCredential(context.Background(), runner, params{Credentials: "gke-production", DryRun: true})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"gcloud config set auth/access_token_file " + accessTokenPath}, runner.commandLines())
+		assert.Equal(t, 0, len(standIn.requests))
+	})
+
+	t.Run("ReturnsErrorIfImpersonationFails", func(t *testing.T) {
+
+		newMetadataServerStandIn(t)
+		withKeylessCredentials(t, `{"project":"my-project","cluster":"my-cluster","zone":"europe-west1-c","impersonateServiceAccount":"someone-else@my-project.iam.gserviceaccount.com"}`)
+
+		// act
+		_, err := initCredential(context.Background(), &fakeCommandRunner{}, params{Credentials: "gke-production"})
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "failed impersonating service account someone-else@my-project.iam.gserviceaccount.com")
+		}
+	})
+
+	t.Run("ReturnsErrorIfCredentialWithKeyfileImpersonates", func(t *testing.T) {
+
+		withKeylessCredentials(t, `{"project":"my-project","serviceAccountKeyfile":"{\"client_email\":\"sa@my-project.iam.gserviceaccount.com\"}","impersonateServiceAccount":"deployer@my-project.iam.gserviceaccount.com"}`)
+		runner := &fakeCommandRunner{}
+
+		// act
+		_, err := initCredential(context.Background(), runner, params{Credentials: "gke-production"})
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "credential gke-production has both a serviceAccountKeyfile and impersonateServiceAccount; impersonation is only supported for credentials without keyfile", err.Error())
+		}
+		assert.Equal(t, 0, len(runner.commands))
+	})
+}
+
+func TestResolveRepositoryAuthWithoutKeyfile(t *testing.T) {
+	t.Run("LogsInToArtifactRegistryWithAccessToken", func(t *testing.T) {
+
+		newMetadataServerStandIn(t)
+		withKeylessCredentials(t, `{"project":"my-project"}`)
+
+		// act
+		auth, err := resolveRepositoryAuth(context.Background(), "oci://europe-docker.pkg.dev/my-project/charts", []dependencyCredential{{Repository: "oci://europe-docker.pkg.dev", Credentials: "gke-production"}})
+
+		assert.Nil(t, err)
+		assert.Equal(t, &repositoryAuth{Username: "oauth2accesstoken", Password: "ya29.metadata-token"}, auth)
+	})
+
+	t.Run("ReturnsErrorIfCredentialWithKeyfileImpersonates", func(t *testing.T) {
+
+		withKeylessCredentials(t, `{"project":"my-project","serviceAccountKeyfile":"{\"client_email\":\"sa@my-project.iam.gserviceaccount.com\"}","impersonateServiceAccount":"deployer@my-project.iam.gserviceaccount.com"}`)
+
+		// act
+		auth, err := resolveRepositoryAuth(context.Background(), "oci://europe-docker.pkg.dev/my-project/charts", []dependencyCredential{{Repository: "oci://europe-docker.pkg.dev", Credentials: "gke-production"}})
+
+		assert.Nil(t, auth)
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "credential gke-production has both a serviceAccountKeyfile and impersonateServiceAccount; impersonation is only supported for credentials without keyfile", err.Error())
+		}
+	})
+}
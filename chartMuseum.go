@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	chartMuseumOnConflictFail      = "fail"
+	chartMuseumOnConflictSkip      = "skip"
+	chartMuseumOnConflictOverwrite = "overwrite"
+)
+
+var chartMuseumOnConflictPolicies = []string{chartMuseumOnConflictFail, chartMuseumOnConflictSkip, chartMuseumOnConflictOverwrite}
+
+var chartMuseumClient = &http.Client{
+	Timeout: time.Second * 60,
+}
+
+// publishToChartMuseum uploads the chart and its provenance file if present with the chartmuseum api, applying the chartMuseumOnConflict policy if the version already exists
+func publishToChartMuseum(ctx context.Context, params params, filename string) error {
+	provenanceFilename := filename + ".prov"
+	if !foundation.FileExists(provenanceFilename) {
+		provenanceFilename = ""
+	}
+
+	authorization := ""
+	if params.ChartMuseumCredentials != "" {
+		var err error
+		authorization, err = chartMuseumAuthorization(params.ChartMuseumCredentials)
+		if err != nil {
+			return err
+		}
+	}
+
+	if params.DryRun {
+		log.Info().Msgf("Dry run: skipping uploading chart %v to %v/api/charts...", filename, params.ChartMuseumURL)
+		return nil
+	}
+
+	log.Info().Msgf("Uploading chart %v to %v...", filename, params.ChartMuseumURL)
+	statusCode, err := uploadChart(ctx, params.ChartMuseumURL, authorization, filename, provenanceFilename, false)
+	if err != nil {
+		return err
+	}
+	if statusCode != http.StatusConflict {
+		return nil
+	}
+
+	// publishing the exact same package again is a no-op, and allowOverwrite replaces a different one, like for the other targets
+	publishedDigest, err := chartMuseumDigest(ctx, params.ChartMuseumURL, authorization, params.Chart, params.Version)
+	if err != nil {
+		return err
+	}
+	policy := params.ChartMuseumOnConflict
+	err = verifyPublishedDigest(params, params.ChartMuseumURL, publishedDigest, filename)
+	switch {
+	case errors.Is(err, errChartAlreadyPublished):
+		return err
+	case err == nil:
+		policy = chartMuseumOnConflictOverwrite
+	}
+
+	switch policy {
+	case chartMuseumOnConflictSkip:
+		log.Warn().Msgf("Chart %v version %v already exists in %v, skipping upload", params.Chart, params.Version, params.ChartMuseumURL)
+		return nil
+	case chartMuseumOnConflictOverwrite:
+		log.Info().Msgf("Chart %v version %v already exists in %v, overwriting it...", params.Chart, params.Version, params.ChartMuseumURL)
+		statusCode, err = uploadChart(ctx, params.ChartMuseumURL, authorization, filename, provenanceFilename, true)
+		if err != nil {
+			return err
+		}
+		if statusCode == http.StatusConflict {
+			return fmt.Errorf("failed overwriting chart %v version %v in %v; make sure the server doesn't disable force overwrites", params.Chart, params.Version, params.ChartMuseumURL)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w, or set chartMuseumOnConflict to %v or %v", err, chartMuseumOnConflictSkip, chartMuseumOnConflictOverwrite)
+}
+
+// chartMuseumDigest returns the digest of a chart version that exists in chartmuseum
+func chartMuseumDigest(ctx context.Context, chartMuseumURL, authorization, chart, version string) (string, error) {
+	versionURL := fmt.Sprintf("%v/api/charts/%v/%v", strings.TrimSuffix(chartMuseumURL, "/"), url.PathEscape(chart), url.PathEscape(version))
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, versionURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed creating request for %v: %w", versionURL, err)
+	}
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	response, err := chartMuseumClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed retrieving chart %v version %v from %v: %w", chart, version, versionURL, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed retrieving chart %v version %v from %v; status code %v", chart, version, versionURL, response.StatusCode)
+	}
+
+	var chartVersion struct {
+		Digest string `json:"digest"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&chartVersion)
+	if err != nil {
+		return "", fmt.Errorf("failed decoding chart %v version %v from %v: %w", chart, version, versionURL, err)
+	}
+	if chartVersion.Digest == "" {
+		return "", fmt.Errorf("chart %v version %v in %v has no digest", chart, version, chartMuseumURL)
+	}
+	return chartVersion.Digest, nil
+}
+
+// uploadChart posts the chart and optional provenance file as multipart form to the chartmuseum api; it returns the status code for a 409 conflict and an error for any other non 2xx response
+func uploadChart(ctx context.Context, chartMuseumURL, authorization, filename, provenanceFilename string, force bool) (int, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	err := addFormFile(writer, "chart", filename)
+	if err != nil {
+		return 0, err
+	}
+	if provenanceFilename != "" {
+		err = addFormFile(writer, "prov", provenanceFilename)
+		if err != nil {
+			return 0, err
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return 0, fmt.Errorf("failed creating upload form for %v: %w", filename, err)
+	}
+
+	uploadURL := strings.TrimSuffix(chartMuseumURL, "/") + "/api/charts"
+	if force {
+		uploadURL += "?force=true"
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, uploadURL, body)
+	if err != nil {
+		return 0, fmt.Errorf("failed creating upload request for %v: %w", uploadURL, err)
+	}
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	if authorization != "" {
+		request.Header.Set("Authorization", authorization)
+	}
+
+	response, err := chartMuseumClient.Do(request)
+	if err != nil {
+		return 0, fmt.Errorf("failed uploading chart %v to %v: %w", filename, uploadURL, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusConflict {
+		return response.StatusCode, nil
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		responseBody, _ := ioutil.ReadAll(response.Body)
+		return response.StatusCode, fmt.Errorf("failed uploading chart %v to %v; status code %v: %v", filename, uploadURL, response.StatusCode, strings.TrimSpace(string(responseBody)))
+	}
+
+	return response.StatusCode, nil
+}
+
+func addFormFile(writer *multipart.Writer, field, filename string) error {
+	file, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("failed reading %v: %w", filename, err)
+	}
+	part, err := writer.CreateFormFile(field, filepath.Base(filename))
+	if err != nil {
+		return fmt.Errorf("failed adding %v to upload form: %w", filename, err)
+	}
+	_, err = io.Copy(part, bytes.NewReader(file))
+	if err != nil {
+		return fmt.Errorf("failed adding %v to upload form: %w", filename, err)
+	}
+	return nil
+}
+
+// chartMuseumAuthorization returns the authorization header for the injected credential of type helm-repository; a token is sent as bearer token
+func chartMuseumAuthorization(credentialName string) (string, error) {
+	credentials, err := readHelmRepositoryCredentials()
+	if err != nil {
+		return "", err
+	}
+	credential := GetHelmRepositoryCredentialsByName(credentials, credentialName)
+	if credential == nil {
+		return "", fmt.Errorf("credential with name %v does not exist", credentialName)
+	}
+
+	if credential.AdditionalProperties.Token != "" {
+		return "Bearer " + credential.AdditionalProperties.Token, nil
+	}
+	request := &http.Request{Header: http.Header{}}
+	request.SetBasicAuth(credential.AdditionalProperties.Username, credential.AdditionalProperties.Password)
+	return request.Header.Get("Authorization"), nil
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// chartMuseumStandIn records the uploads to /api/charts and responds with 409 for existing charts unless forced; an existing chart has the digest set, or a digest that differs from any package
+type chartMuseumStandIn struct {
+	exists  bool
+	digest  string
+	uploads []*http.Request
+	files   []map[string]string
+}
+
+func (s *chartMuseumStandIn) start(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && r.URL.Path == "/api/charts/mychart/1.0.0" && s.exists {
+			digest := s.digest
+			if digest == "" {
+				digest = "0000000000000000000000000000000000000000000000000000000000000000"
+			}
+			_, _ = w.Write([]byte(`{"name":"mychart","version":"1.0.0","digest":"` + digest + `"}`))
+			return
+		}
+		if r.Method != http.MethodPost || r.URL.Path != "/api/charts" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		files := map[string]string{}
+		for field, headers := range r.MultipartForm.File {
+			f, _ := headers[0].Open()
+			content, _ := ioutil.ReadAll(f)
+			files[field] = headers[0].Filename + ":" + string(content)
+		}
+		s.uploads = append(s.uploads, r)
+		s.files = append(s.files, files)
+
+		if s.exists && r.URL.Query().Get("force") == "" {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"error":"file already exists"}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"saved":true}`))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPublishToChartMuseum(t *testing.T) {
+	t.Run("UploadsChartAndProvenanceFileWithBasicAuth", func(t *testing.T) {
+
+		inTempDir(t)
+		withHelmRepositoryCredentials(t)
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz", []byte("chart"), 0644)
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz.prov", []byte("signature"), 0644)
+		standIn := &chartMuseumStandIn{}
+		server := standIn.start(t)
+		params := params{
+			Chart:                  "mychart",
+			Version:                "1.0.0",
+			ChartMuseumURL:         server.URL + "/",
+			ChartMuseumCredentials: "basic-auth",
+			ChartMuseumOnConflict:  "fail",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), &fakeCommandRunner{}, params)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(standIn.uploads)) {
+			username, password, ok := standIn.uploads[0].BasicAuth()
+			assert.True(t, ok)
+			assert.Equal(t, "user", username)
+			assert.Equal(t, "s3cr3t", password)
+			assert.Equal(t, map[string]string{"chart": "mychart-1.0.0.tgz:chart", "prov": "mychart-1.0.0.tgz.prov:signature"}, standIn.files[0])
+		}
+	})
+
+	t.Run("UploadsChartWithoutProvenanceFileWithBearerToken", func(t *testing.T) {
+
+		inTempDir(t)
+		withHelmRepositoryCredentials(t)
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz", []byte("chart"), 0644)
+		standIn := &chartMuseumStandIn{}
+		server := standIn.start(t)
+		params := params{
+			Chart:                  "mychart",
+			Version:                "1.0.0",
+			ChartMuseumURL:         server.URL,
+			ChartMuseumCredentials: "token-auth",
+			ChartMuseumOnConflict:  "fail",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), &fakeCommandRunner{}, params)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 1, len(standIn.uploads)) {
+			assert.Equal(t, "Bearer t0k3n", standIn.uploads[0].Header.Get("Authorization"))
+			assert.Equal(t, map[string]string{"chart": "mychart-1.0.0.tgz:chart"}, standIn.files[0])
+		}
+	})
+
+	t.Run("ReturnsErrorIfChartExistsAndPolicyIsFail", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz", []byte("chart"), 0644)
+		standIn := &chartMuseumStandIn{exists: true}
+		server := standIn.start(t)
+		params := params{
+			Chart:                 "mychart",
+			Version:               "1.0.0",
+			ChartMuseumURL:        server.URL,
+			ChartMuseumOnConflict: "fail",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), &fakeCommandRunner{}, params)
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "chart mychart version 1.0.0 is already published to "+server.URL+" with digest")
+			assert.Contains(t, err.Error(), "or set chartMuseumOnConflict to skip or overwrite")
+		}
+		assert.Equal(t, 1, len(standIn.uploads))
+	})
+
+	t.Run("SucceedsWithoutOverwriteIfChartExistsWithSameDigest", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz", []byte("chart"), 0644)
+		digest, _ := fileDigest("mychart-1.0.0.tgz")
+		standIn := &chartMuseumStandIn{exists: true, digest: digest}
+		server := standIn.start(t)
+		params := params{
+			Chart:                 "mychart",
+			Version:               "1.0.0",
+			ChartMuseumURL:        server.URL,
+			ChartMuseumOnConflict: "fail",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), &fakeCommandRunner{}, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(standIn.uploads))
+	})
+
+	t.Run("ForcesUploadIfChartExistsWithDifferentDigestAndAllowOverwriteIsSet", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz", []byte("chart"), 0644)
+		standIn := &chartMuseumStandIn{exists: true}
+		server := standIn.start(t)
+		params := params{
+			Chart:                 "mychart",
+			Version:               "1.0.0",
+			ChartMuseumURL:        server.URL,
+			ChartMuseumOnConflict: "fail",
+			AllowOverwrite:        true,
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), &fakeCommandRunner{}, params)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(standIn.uploads)) {
+			assert.Equal(t, "true", standIn.uploads[1].URL.Query().Get("force"))
+		}
+	})
+
+	t.Run("SkipsIfChartExistsAndPolicyIsSkip", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz", []byte("chart"), 0644)
+		standIn := &chartMuseumStandIn{exists: true}
+		server := standIn.start(t)
+		params := params{
+			Chart:                 "mychart",
+			Version:               "1.0.0",
+			ChartMuseumURL:        server.URL,
+			ChartMuseumOnConflict: "skip",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), &fakeCommandRunner{}, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(standIn.uploads))
+	})
+
+	t.Run("ForcesUploadIfChartExistsAndPolicyIsOverwrite", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz", []byte("chart"), 0644)
+		standIn := &chartMuseumStandIn{exists: true}
+		server := standIn.start(t)
+		params := params{
+			Chart:                 "mychart",
+			Version:               "1.0.0",
+			ChartMuseumURL:        server.URL,
+			ChartMuseumOnConflict: "overwrite",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), &fakeCommandRunner{}, params)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(standIn.uploads)) {
+			assert.Equal(t, "true", standIn.uploads[1].URL.Query().Get("force"))
+		}
+	})
+
+	t.Run("ReturnsErrorWithResponseBodyForServerError", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz", []byte("chart"), 0644)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("storage unavailable"))
+		}))
+		defer server.Close()
+		params := params{
+			Chart:                 "mychart",
+			Version:               "1.0.0",
+			ChartMuseumURL:        server.URL,
+			ChartMuseumOnConflict: "fail",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), &fakeCommandRunner{}, params)
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "status code 500: storage unavailable")
+		}
+	})
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	// kubeconfigPath is where the kubeconfig for kubectl and helm is written by the cluster providers that don't use gcloud
+	kubeconfigPath = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	// inClusterServiceAccountPath is where kubernetes mounts the token and certificate authority of the service account of a pod
+	inClusterServiceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+)
+
+// clusterCredential is an injected credential of one of the types in clusterProviders, with its additional properties left for its provider to unmarshal
+type clusterCredential struct {
+	Name                 string          `json:"name,omitempty"`
+	Type                 string          `json:"type,omitempty"`
+	AdditionalProperties json.RawMessage `json:"additionalProperties,omitempty"`
+}
+
+// clusterProvider configures kubectl and helm to connect to the cluster of a credential
+type clusterProvider interface {
+	InitKubectl(ctx context.Context, runner CommandRunner, params params, credential clusterCredential) error
+}
+
+// clusterProviders maps the credential types to the providers that can connect to their clusters
+var clusterProviders = map[string]clusterProvider{
+	"kubernetes-engine":     gkeClusterProvider{},
+	"kubernetes-config":     kubeconfigClusterProvider{},
+	"kubernetes-token":      tokenClusterProvider{},
+	"kubernetes-in-cluster": inClusterProvider{},
+}
+
+// clusterCredentialsPaths returns the files the credentials of each type in clusterProviders are injected in
+func clusterCredentialsPaths() map[string]string {
+	return map[string]string{
+		"kubernetes-engine":     credentialsFilePath(*credentialsPath),
+		"kubernetes-config":     credentialsFilePath(*kubernetesConfigCredentialsPath),
+		"kubernetes-token":      credentialsFilePath(*kubernetesTokenCredentialsPath),
+		"kubernetes-in-cluster": credentialsFilePath(*kubernetesInClusterCredentialsPath),
+	}
+}
+
+// findClusterCredential looks up the credential by name in the injected credentials of all types in clusterProviders
+func findClusterCredential(name string) (*clusterCredential, error) {
+	types := []string{}
+	for t := range clusterProviders {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	paths := clusterCredentialsPaths()
+	injected := 0
+	for _, t := range types {
+		if !foundation.FileExists(paths[t]) {
+			continue
+		}
+		injected++
+
+		log.Info().Msgf("Reading credentials from file at path %v...", paths[t])
+		var credentials []clusterCredential
+		err := readCredentialsFile(paths[t], &credentials)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range credentials {
+			if c.Name == name {
+				c.Type = t
+				return &c, nil
+			}
+		}
+	}
+
+	if injected == 0 {
+		return nil, fmt.Errorf("credentials of type %v are not injected; configure this extension as trusted and inject credentials of one of these types", strings.Join(types, ", "))
+	}
+	return nil, fmt.Errorf("credential with name %v does not exist", name)
+}
+
+// gkeClusterProvider gets the credentials for a gke cluster with gcloud, authenticated as the service account of the credential
+type gkeClusterProvider struct{}
+
+func (gkeClusterProvider) InitKubectl(ctx context.Context, runner CommandRunner, params params, _ clusterCredential) error {
+
+	credential, err := initCredential(ctx, runner, params)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msg("Setting gcloud project")
+	err = runner.RunCommandWithArgs(ctx, "gcloud", []string{"config", "set", "project", credential.AdditionalProperties.Project})
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Getting gke credentials for cluster %v", credential.AdditionalProperties.Cluster)
+	clustersGetCredentialsArsgs := []string{"container", "clusters", "get-credentials", credential.AdditionalProperties.Cluster}
+	if credential.AdditionalProperties.Zone != "" {
+		clustersGetCredentialsArsgs = append(clustersGetCredentialsArsgs, "--zone", credential.AdditionalProperties.Zone)
+	} else if credential.AdditionalProperties.Region != "" {
+		clustersGetCredentialsArsgs = append(clustersGetCredentialsArsgs, "--region", credential.AdditionalProperties.Region)
+	} else {
+		return fmt.Errorf("credentials have no zone or region; at least one of them has to be defined")
+	}
+	return runner.RunCommandWithArgs(ctx, "gcloud", clustersGetCredentialsArsgs)
+}
+
+// KubeconfigCredentialAdditionalProperties contains the non standard fields for credentials of type kubernetes-config
+type KubeconfigCredentialAdditionalProperties struct {
+	Kubeconfig string `json:"kubeconfig,omitempty"`
+	Context    string `json:"context,omitempty"`
+}
+
+// kubeconfigClusterProvider uses the kubeconfig stored in the credential as is, for clusters like eks or aks
+type kubeconfigClusterProvider struct{}
+
+func (kubeconfigClusterProvider) InitKubectl(ctx context.Context, runner CommandRunner, params params, credential clusterCredential) error {
+	var properties KubeconfigCredentialAdditionalProperties
+	err := json.Unmarshal(credential.AdditionalProperties, &properties)
+	if err != nil {
+		return fmt.Errorf("failed unmarshalling additional properties of credential %v: %w", credential.Name, err)
+	}
+	if properties.Kubeconfig == "" {
+		return fmt.Errorf("credential %v has no kubeconfig", credential.Name)
+	}
+
+	err = writeKubeconfig(params, []byte(properties.Kubeconfig))
+	if err != nil {
+		return err
+	}
+
+	if properties.Context != "" {
+		log.Info().Msgf("Using kubeconfig context %v", properties.Context)
+		return runner.RunCommandWithArgs(ctx, "kubectl", []string{"config", "use-context", properties.Context})
+	}
+	return nil
+}
+
+// KubernetesTokenCredentialAdditionalProperties contains the non standard fields for credentials of type kubernetes-token
+type KubernetesTokenCredentialAdditionalProperties struct {
+	Server                string `json:"server,omitempty"`
+	Token                 string `json:"token,omitempty"`
+	CertificateAuthority  string `json:"certificateAuthority,omitempty"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTlsVerify,omitempty"`
+}
+
+// tokenClusterProvider connects to the api server of the credential with a bearer token, like the token of a service account
+type tokenClusterProvider struct{}
+
+func (tokenClusterProvider) InitKubectl(ctx context.Context, runner CommandRunner, params params, credential clusterCredential) error {
+	var properties KubernetesTokenCredentialAdditionalProperties
+	err := json.Unmarshal(credential.AdditionalProperties, &properties)
+	if err != nil {
+		return fmt.Errorf("failed unmarshalling additional properties of credential %v: %w", credential.Name, err)
+	}
+	if properties.Server == "" || properties.Token == "" {
+		return fmt.Errorf("credential %v needs both a server and a token", credential.Name)
+	}
+	if properties.CertificateAuthority == "" && !properties.InsecureSkipTLSVerify {
+		return fmt.Errorf("credential %v has no certificateAuthority; set insecureSkipTlsVerify to connect without verifying the server certificate", credential.Name)
+	}
+
+	certificateAuthority, err := decodeCertificateAuthority(properties.CertificateAuthority)
+	if err != nil {
+		return fmt.Errorf("failed decoding certificateAuthority of credential %v: %w", credential.Name, err)
+	}
+
+	secretMasker.AddSecrets(properties.Token)
+	config := newKubeconfig(credential.Name, properties.Server, certificateAuthority, properties.InsecureSkipTLSVerify, kubeconfigUser{Token: properties.Token})
+
+	return writeKubeconfig(params, config)
+}
+
+// inClusterProvider connects to the cluster the extension runs in with the service account of its pod
+type inClusterProvider struct{}
+
+func (inClusterProvider) InitKubectl(ctx context.Context, runner CommandRunner, params params, credential clusterCredential) error {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return fmt.Errorf("credential %v is of type kubernetes-in-cluster, but the extension doesn't run inside a kubernetes cluster", credential.Name)
+	}
+
+	tokenFile := filepath.Join(inClusterServiceAccountPath, "token")
+	if !foundation.FileExists(tokenFile) {
+		return fmt.Errorf("service account token %v does not exist; make sure it's mounted into the pod", tokenFile)
+	}
+	certificateAuthority, err := ioutil.ReadFile(filepath.Join(inClusterServiceAccountPath, "ca.crt"))
+	if err != nil {
+		return fmt.Errorf("failed reading certificate authority of the service account: %w", err)
+	}
+
+	// the token file is referred to instead of copied, so kubectl picks up the rotated token
+	config := newKubeconfig(credential.Name, "https://"+net.JoinHostPort(host, port), certificateAuthority, false, kubeconfigUser{TokenFile: tokenFile})
+
+	return writeKubeconfig(params, config)
+}
+
+// decodeCertificateAuthority accepts a pem encoded certificate authority as is or base64 encoded like in a kubeconfig
+func decodeCertificateAuthority(certificateAuthority string) ([]byte, error) {
+	if certificateAuthority == "" || strings.HasPrefix(strings.TrimSpace(certificateAuthority), "-----BEGIN") {
+		return []byte(certificateAuthority), nil
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(certificateAuthority))
+}
+
+type kubeconfig struct {
+	APIVersion     string                `yaml:"apiVersion"`
+	Kind           string                `yaml:"kind"`
+	Clusters       []kubeconfigNamedItem `yaml:"clusters"`
+	Users          []kubeconfigNamedItem `yaml:"users"`
+	Contexts       []kubeconfigNamedItem `yaml:"contexts"`
+	CurrentContext string                `yaml:"current-context"`
+}
+
+type kubeconfigNamedItem struct {
+	Name    string             `yaml:"name"`
+	Cluster *kubeconfigCluster `yaml:"cluster,omitempty"`
+	User    *kubeconfigUser    `yaml:"user,omitempty"`
+	Context *kubeconfigContext `yaml:"context,omitempty"`
+}
+
+type kubeconfigCluster struct {
+	Server                   string `yaml:"server"`
+	CertificateAuthorityData string `yaml:"certificate-authority-data,omitempty"`
+	InsecureSkipTLSVerify    bool   `yaml:"insecure-skip-tls-verify,omitempty"`
+}
+
+type kubeconfigUser struct {
+	Token     string `yaml:"token,omitempty"`
+	TokenFile string `yaml:"tokenFile,omitempty"`
+}
+
+type kubeconfigContext struct {
+	Cluster string `yaml:"cluster"`
+	User    string `yaml:"user"`
+}
+
+// newKubeconfig returns a kubeconfig with a single cluster, user and context named after the credential
+func newKubeconfig(name, server string, certificateAuthority []byte, insecureSkipTLSVerify bool, user kubeconfigUser) []byte {
+	cluster := kubeconfigCluster{Server: server, InsecureSkipTLSVerify: insecureSkipTLSVerify}
+	if len(certificateAuthority) > 0 {
+		cluster.CertificateAuthorityData = base64.StdEncoding.EncodeToString(certificateAuthority)
+	}
+
+	data, _ := yaml.Marshal(kubeconfig{
+		APIVersion:     "v1",
+		Kind:           "Config",
+		Clusters:       []kubeconfigNamedItem{{Name: name, Cluster: &cluster}},
+		Users:          []kubeconfigNamedItem{{Name: name, User: &user}},
+		Contexts:       []kubeconfigNamedItem{{Name: name, Context: &kubeconfigContext{Cluster: name, User: name}}},
+		CurrentContext: name,
+	})
+	return data
+}
+
+// writeKubeconfig writes the kubeconfig to kubeconfigPath, readable only by the current user
+func writeKubeconfig(params params, config []byte) error {
+	if params.DryRun {
+		log.Info().Msgf("Dry run: skipping writing kubeconfig to %v...", kubeconfigPath)
+		return nil
+	}
+
+	log.Info().Msgf("Writing kubeconfig to %v...", kubeconfigPath)
+	err := os.MkdirAll(filepath.Dir(kubeconfigPath), 0700)
+	if err != nil {
+		return fmt.Errorf("failed creating directory for kubeconfig: %w", err)
+	}
+	err = ioutil.WriteFile(kubeconfigPath, config, 0600)
+	if err != nil {
+		return fmt.Errorf("failed writing kubeconfig to %v: %w", kubeconfigPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withClusterCredentials injects credentials of a non-gke cluster type and writes the kubeconfig to a temporary directory for the duration of the test
+func withClusterCredentials(t *testing.T, credentialType string, credentials string) {
+	dir := t.TempDir()
+
+	paths := map[string]*string{
+		"kubernetes-config":     kubernetesConfigCredentialsPath,
+		"kubernetes-token":      kubernetesTokenCredentialsPath,
+		"kubernetes-in-cluster": kubernetesInClusterCredentialsPath,
+	}
+	credentialsFile := filepath.Join(dir, "credentials.json")
+	if err := ioutil.WriteFile(credentialsFile, []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	originalCredentialsPath, originalKubeconfigPath := *paths[credentialType], kubeconfigPath
+	*paths[credentialType] = credentialsFile
+	kubeconfigPath = filepath.Join(dir, ".kube", "config")
+	t.Cleanup(func() {
+		*paths[credentialType] = originalCredentialsPath
+		kubeconfigPath = originalKubeconfigPath
+	})
+}
+
+func TestInitKubectl(t *testing.T) {
+	t.Run("WritesKubeconfigOfCredentialAndUsesItsContext", func(t *testing.T) {
+
+		withClusterCredentials(t, "kubernetes-config", `[{"name":"eks-production","type":"kubernetes-config","additionalProperties":{"kubeconfig":"apiVersion: v1\nkind: Config\n","context":"arn:aws:eks:eu-west-1:123456789012:cluster/production"}}]`)
+		runner := &fakeCommandRunner{}
+
+		// act
+		err := initKubectl(context.Background(), runner, params{Credentials: "eks-production"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"kubectl config use-context arn:aws:eks:eu-west-1:123456789012:cluster/production"}, runner.commandLines())
+		data, _ := ioutil.ReadFile(kubeconfigPath)
+		assert.Equal(t, "apiVersion: v1\nkind: Config\n", string(data))
+		info, _ := os.Stat(kubeconfigPath)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+
+	t.Run("WritesKubeconfigForServerWithTokenAndBase64EncodedCertificateAuthority", func(t *testing.T) {
+
+		withClusterCredentials(t, "kubernetes-token", `[{"name":"aks-production","type":"kubernetes-token","additionalProperties":{"server":"https://aks.example.com:443","token":"t0k3n-abc","certificateAuthority":"LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t"}}]`)
+		runner := &fakeCommandRunner{}
+
+		// act
+		err := initKubectl(context.Background(), runner, params{Credentials: "aks-production"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(runner.commands))
+		data, _ := ioutil.ReadFile(kubeconfigPath)
+		assert.Equal(t, `apiVersion: v1
+kind: Config
+clusters:
+- name: aks-production
+  cluster:
+    server: https://aks.example.com:443
+    certificate-authority-data: LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t
+users:
+- name: aks-production
+  user:
+    token: t0k3n-abc
+contexts:
+- name: aks-production
+  context:
+    cluster: aks-production
+    user: aks-production
+current-context: aks-production
+`, string(data))
+	})
+
+	t.Run("ReturnsErrorForTokenCredentialWithoutCertificateAuthority", func(t *testing.T) {
+
+		withClusterCredentials(t, "kubernetes-token", `[{"name":"onprem","type":"kubernetes-token","additionalProperties":{"server":"https://10.0.0.1:6443","token":"t0k3n-abc"}}]`)
+
+		// act
+		err := initKubectl(context.Background(), &fakeCommandRunner{}, params{Credentials: "onprem"})
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "credential onprem has no certificateAuthority; set insecureSkipTlsVerify to connect without verifying the server certificate", err.Error())
+		}
+	})
+
+	t.Run("WritesKubeconfigReferringToServiceAccountTokenOfPod", func(t *testing.T) {
+
+		withClusterCredentials(t, "kubernetes-in-cluster", `[{"name":"in-cluster","type":"kubernetes-in-cluster"}]`)
+		serviceAccountDir := t.TempDir()
+		_ = ioutil.WriteFile(filepath.Join(serviceAccountDir, "token"), []byte("t0k3n"), 0600)
+		_ = ioutil.WriteFile(filepath.Join(serviceAccountDir, "ca.crt"), []byte("-----BEGIN CERTIFICATE-----"), 0600)
+		original := inClusterServiceAccountPath
+		inClusterServiceAccountPath = serviceAccountDir
+		t.Cleanup(func() {
+			inClusterServiceAccountPath = original
+		})
+		t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+		t.Setenv("KUBERNETES_SERVICE_PORT", "443")
+
+		// act
+		err := initKubectl(context.Background(), &fakeCommandRunner{}, params{Credentials: "in-cluster"})
+
+		assert.Nil(t, err)
+		data, _ := ioutil.ReadFile(kubeconfigPath)
+		assert.Contains(t, string(data), "server: https://10.0.0.1:443\n")
+		assert.Contains(t, string(data), "certificate-authority-data: LS0tLS1CRUdJTiBDRVJUSUZJQ0FURS0tLS0t\n")
+		assert.Contains(t, string(data), "tokenFile: "+filepath.Join(serviceAccountDir, "token")+"\n")
+	})
+
+	t.Run("UsesGcloudForGkeCredentials", func(t *testing.T) {
+
+		withCredentials(t)
+		runner := &fakeCommandRunner{}
+
+		// act
+		err := initKubectl(context.Background(), runner, params{Credentials: "gke-production"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "gcloud container clusters get-credentials my-cluster --zone europe-west1-c", runner.commandLines()[3])
+	})
+
+	t.Run("ReturnsErrorIfCredentialDoesNotExist", func(t *testing.T) {
+
+		withCredentials(t)
+
+		// act
+		err := initKubectl(context.Background(), &fakeCommandRunner{}, params{Credentials: "eks-production"})
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "credential with name eks-production does not exist", err.Error())
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// chartAttestationPredicateType identifies the in-toto predicate recording how a published chart was built
+const chartAttestationPredicateType = "https://estafette.io/attestations/helm-chart/v1"
+
+// buildInfo describes the estafette build that produced the chart; it's passed in through environment variables instead of parameters
+type buildInfo struct {
+	Version       string `json:"version,omitempty"`
+	GitRepository string `json:"gitRepository,omitempty"`
+	GitRevision   string `json:"gitRevision,omitempty"`
+}
+
+// chartAttestationPredicate is the predicate of the in-toto attestation attached to a published chart
+type chartAttestationPredicate struct {
+	Build buildInfo             `json:"build"`
+	Chart chartAttestationChart `json:"chart"`
+}
+
+type chartAttestationChart struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	AppVersion string `json:"appVersion,omitempty"`
+	Digest     string `json:"digest"`
+}
+
+// signPublishedChart signs the chart pushed to the oci registry with cosign and attaches an attestation recording the build it was created by; without cosignKey the signing is keyless, using the identity of the pipeline
+func signPublishedChart(ctx context.Context, runner CommandRunner, params params, filename string, auth *repositoryAuth) error {
+	repository := strings.TrimSuffix(strings.TrimPrefix(params.Registry, "oci://"), "/") + "/" + params.Chart
+
+	// sign the manifest by digest, so the signature can't end up on another chart if the tag is moved in the meantime
+	reference := repository + ":" + params.Version
+	chartDigest := "sha256:<digest of " + filename + ">"
+	if params.DryRun {
+		log.Info().Msgf("Dry run: skipping resolving the manifest digest of %v and the digest of %v...", reference, filename)
+	} else {
+		digest, err := fileDigest(filename)
+		if err != nil {
+			return err
+		}
+		chartDigest = "sha256:" + digest
+
+		manifestDigest, err := resolveManifestDigest(ctx, repository, params.Version, auth)
+		if err != nil {
+			return err
+		}
+		reference = repository + "@" + manifestDigest
+	}
+
+	if auth != nil {
+		registry := strings.Split(repository, "/")[0]
+		log.Info().Msgf("Logging in to registry %v for cosign...", registry)
+		err := runner.RunCommandWithArgsAndStdin(ctx, "cosign", []string{"login", registry, "--username", auth.Username, "--password-stdin"}, auth.Password)
+		if err != nil {
+			return err
+		}
+	}
+
+	keyArgs := []string{}
+	if params.CosignKey != "" {
+		keyArgs = []string{"--key", params.CosignKey}
+	} else {
+		log.Info().Msg("No cosignKey set, signing keyless with the identity of the pipeline...")
+	}
+
+	log.Info().Msgf("Signing %v with cosign...", reference)
+	err := runner.RunCommandWithArgs(ctx, "cosign", append(append([]string{"sign", "--yes"}, keyArgs...), reference))
+	if err != nil {
+		return err
+	}
+
+	predicate := chartAttestationPredicate{
+		Build: params.Build,
+		Chart: chartAttestationChart{
+			Name:       params.Chart,
+			Version:    params.Version,
+			AppVersion: params.AppVersion,
+			Digest:     chartDigest,
+		},
+	}
+	predicateBytes, err := json.MarshalIndent(predicate, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed marshalling attestation predicate: %w", err)
+	}
+
+	tempDirectory, err := ioutil.TempDir("", "cosign")
+	if err != nil {
+		return fmt.Errorf("failed creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDirectory)
+
+	predicatePath := filepath.Join(tempDirectory, "predicate.json")
+	err = ioutil.WriteFile(predicatePath, predicateBytes, 0600)
+	if err != nil {
+		return fmt.Errorf("failed writing attestation predicate to %v: %w", predicatePath, err)
+	}
+
+	log.Info().Msgf("Attaching attestation for build %v at revision %v to %v...", params.Build.Version, params.Build.GitRevision, reference)
+	return runner.RunCommandWithArgs(ctx, "cosign", append(append([]string{"attest", "--yes", "--type", chartAttestationPredicateType, "--predicate", predicatePath}, keyArgs...), reference))
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// registryStandIn serves the manifest digest of charts/mychart:1.0.0 to requests with a bearer token, handing out tokens for basic auth if credentials are set
+type registryStandIn struct {
+	username string
+	password string
+	digest   string
+}
+
+func (s *registryStandIn) start(t *testing.T) string {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/token":
+			if s.username != "" {
+				if username, password, ok := r.BasicAuth(); !ok || username != s.username || password != s.password {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+			}
+			_, _ = w.Write([]byte(`{"token":"registry-token"}`))
+		case r.Method == http.MethodHead && r.URL.Path == "/v2/charts/mychart/manifests/1.0.0":
+			if r.Header.Get("Authorization") != "Bearer registry-token" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="stand-in",scope="repository:charts/mychart:pull"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Docker-Content-Digest", s.digest)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	originalRegistryClient := registryClient
+	registryClient = server.Client()
+	t.Cleanup(func() {
+		registryClient = originalRegistryClient
+	})
+
+	return strings.TrimPrefix(server.URL, "https://")
+}
+
+func TestSignPublishedChart(t *testing.T) {
+	t.Run("SignsAndAttestsManifestDigestWithKey", func(t *testing.T) {
+
+		inTempDir(t)
+		withHelmRepositoryCredentials(t)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		chartDigest, _ := fileDigest("mychart-1.0.0.tgz")
+		host := (&registryStandIn{username: "user", password: "s3cr3t", digest: "sha256:1234"}).start(t)
+		var predicate chartAttestationPredicate
+		runner := &fakeCommandRunner{
+			errors: map[string]error{
+				"helm pull": errors.New("exit status 1: Error: " + host + "/charts/mychart:1.0.0: not found"),
+			},
+			effects: map[string]func([]string){
+				"cosign attest": func(argv []string) {
+					data, _ := ioutil.ReadFile(argv[6])
+					_ = json.Unmarshal(data, &predicate)
+				},
+			},
+		}
+		params := params{
+			Chart:               "mychart",
+			Version:             "1.0.0",
+			AppVersion:          "1.0.0",
+			Registry:            "oci://" + host + "/charts",
+			RegistryCredentials: "basic-auth",
+			Cosign:              true,
+			CosignKey:           "cosign.key",
+			Build: buildInfo{
+				Version:       "1.0.0",
+				GitRepository: "github.com/estafette/mychart",
+				GitRevision:   "0123abc",
+			},
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		commandLines := runner.commandLines()
+		if assert.Equal(t, 6, len(commandLines)) {
+			assert.Equal(t, "helm push mychart-1.0.0.tgz oci://"+host+"/charts", commandLines[2])
+			assert.Equal(t, "cosign login "+host+" --username user --password-stdin", commandLines[3])
+			assert.Equal(t, "cosign sign --yes --key cosign.key "+host+"/charts/mychart@sha256:1234", commandLines[4])
+			assert.True(t, strings.HasPrefix(commandLines[5], "cosign attest --yes --type https://estafette.io/attestations/helm-chart/v1 --predicate "))
+			assert.True(t, strings.HasSuffix(commandLines[5], " --key cosign.key "+host+"/charts/mychart@sha256:1234"))
+		}
+		assert.Equal(t, "s3cr3t", runner.inputs[3])
+		assert.Equal(t, params.Build, predicate.Build)
+		assert.Equal(t, chartAttestationChart{Name: "mychart", Version: "1.0.0", AppVersion: "1.0.0", Digest: "sha256:" + chartDigest}, predicate.Chart)
+	})
+
+	t.Run("SignsKeylessIfCosignKeyIsNotSet", func(t *testing.T) {
+
+		inTempDir(t)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		host := (&registryStandIn{digest: "sha256:1234"}).start(t)
+		runner := &fakeCommandRunner{}
+
+		// act
+		err := signPublishedChart(context.Background(), runner, params{Chart: "mychart", Version: "1.0.0", Registry: "oci://" + host + "/charts/", Cosign: true}, "mychart-1.0.0.tgz", nil)
+
+		assert.Nil(t, err)
+		commandLines := runner.commandLines()
+		if assert.Equal(t, 2, len(commandLines)) {
+			assert.Equal(t, "cosign sign --yes "+host+"/charts/mychart@sha256:1234", commandLines[0])
+			assert.True(t, strings.HasSuffix(commandLines[1], ".json "+host+"/charts/mychart@sha256:1234"))
+		}
+	})
+
+	t.Run("ReturnsErrorIfRegistryRejectsCredentials", func(t *testing.T) {
+
+		inTempDir(t)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		host := (&registryStandIn{username: "user", password: "s3cr3t", digest: "sha256:1234"}).start(t)
+		runner := &fakeCommandRunner{}
+
+		// act
+		err := signPublishedChart(context.Background(), runner, params{Chart: "mychart", Version: "1.0.0", Registry: "oci://" + host + "/charts", Cosign: true}, "mychart-1.0.0.tgz", &repositoryAuth{Username: "user", Password: "wrong"})
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "failed requesting registry token")
+		}
+		assert.Equal(t, 0, len(runner.commands))
+	})
+
+	t.Run("SignsTagWithoutQueryingRegistryInDryRun", func(t *testing.T) {
+
+		// the package doesn't exist in a dry run, since packaging is skipped as well
+		inTempDir(t)
+		runner := &fakeCommandRunner{}
+
+		// act
+		err := signPublishedChart(context.Background(), runner, params{Chart: "mychart", Version: "1.0.0", Registry: "oci://europe-docker.pkg.dev/my-project/charts", Cosign: true, DryRun: true}, "mychart-1.0.0.tgz", nil)
+
+		assert.Nil(t, err)
+		if assert.Equal(t, 2, len(runner.commands)) {
+			assert.Equal(t, "cosign sign --yes europe-docker.pkg.dev/my-project/charts/mychart:1.0.0", runner.commandLines()[0])
+		}
+	})
+}
+
+func TestParseChallengeParams(t *testing.T) {
+	t.Run("ReturnsQuotedAndUnquotedValues", func(t *testing.T) {
+
+		// act
+		challengeParams := parseChallengeParams(`realm="https://auth.example.com/token",service=registry.example.com,scope="repository:charts/mychart:pull,push"`)
+
+		assert.Equal(t, map[string]string{"realm": "https://auth.example.com/token", "service": "registry.example.com", "scope": "repository:charts/mychart:pull,push"}, challengeParams)
+	})
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strings"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+// serviceAccountKeyfilePath is where the service account keyfile of the selected credential is stored for gcloud and helm gcs
+var serviceAccountKeyfilePath = "/key-file.json"
+
+// initializedCredential is the name of the credential gcloud got authenticated with by initCredential, so sops knows it can decrypt with gcp kms
+var initializedCredential string
+
+// initCredential authenticates gcloud as the service account of the kubernetes-engine credential, with its keyfile or with ambient credentials if it has none
+func initCredential(ctx context.Context, runner CommandRunner, params params) (*GKECredentials, error) {
+
+	log.Info().Msg("Unmarshalling injected credentials...")
+	credentials, err := readGKECredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Msgf("Checking if credential %v exists...", params.Credentials)
+	credential := GetCredentialsByName(credentials, params.Credentials)
+	if credential == nil {
+		return nil, fmt.Errorf("credential with name %v does not exist", params.Credentials)
+	}
+	err = checkImpersonation(credential)
+	if err != nil {
+		return nil, err
+	}
+
+	if credential.AdditionalProperties.ServiceAccountKeyfile == "" {
+		err = initAmbientCredential(ctx, runner, params, credential)
+		if err != nil {
+			return nil, err
+		}
+		initializedCredential = credential.Name
+		return credential, nil
+	}
+
+	if params.DryRun {
+		log.Info().Msgf("Dry run: skipping storing gcp credential %v on disk...", params.Credentials)
+	} else {
+		log.Info().Msgf("Storing gcp credential %v on disk...", params.Credentials)
+		err = ioutil.WriteFile(serviceAccountKeyfilePath, []byte(credential.AdditionalProperties.ServiceAccountKeyfile), 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed writing service account keyfile: %w", err)
+		}
+	}
+
+	log.Info().Msg("Retrieving service account email from credentials...")
+	var keyFileMap map[string]interface{}
+	err = json.Unmarshal([]byte(credential.AdditionalProperties.ServiceAccountKeyfile), &keyFileMap)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling service account keyfile: %w", err)
+	}
+
+	var saClientEmail string
+	if saClientEmailIntfc, ok := keyFileMap["client_email"]; !ok {
+		return nil, fmt.Errorf("field client_email missing from service account keyfile")
+	} else {
+		if t, aok := saClientEmailIntfc.(string); !aok {
+			return nil, fmt.Errorf("field client_email not of type string")
+		} else {
+			saClientEmail = t
+		}
+	}
+
+	log.Info().Msg("Authenticating to google cloud")
+	err = runner.RunCommandWithArgs(ctx, "gcloud", []string{"auth", "activate-service-account", saClientEmail, "--key-file", serviceAccountKeyfilePath})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Msgf("Setting gcloud account to %v", saClientEmail)
+	err = runner.RunCommandWithArgs(ctx, "gcloud", []string{"config", "set", "account", saClientEmail})
+	if err != nil {
+		return nil, err
+	}
+
+	initializedCredential = credential.Name
+	return credential, nil
+}
+
+// readGKECredentials reads the injected credentials of type kubernetes-engine
+func readGKECredentials() (credentials []GKECredentials, err error) {
+
+	path := credentialsFilePath(*credentialsPath)
+	if !foundation.FileExists(path) {
+		return nil, fmt.Errorf("credentials of type kubernetes-engine are not injected; configure this extension as trusted and inject credentials of type kubernetes-engine")
+	}
+
+	log.Info().Msgf("Reading credentials from file at path %v...", path)
+	err = readCredentialsFile(path, &credentials)
+	return
+}
+
+// credentialsFilePath returns the path of a mounted credentials file, prefixed with the drive on windows
+func credentialsFilePath(path string) string {
+	if runtime.GOOS == "windows" && !strings.HasPrefix(path, "C:") {
+		return "C:" + path
+	}
+	return path
+}
+
+// readHelmRepositoryCredentials reads the injected credentials of type helm-repository
+func readHelmRepositoryCredentials() (credentials []HelmRepositoryCredentials, err error) {
+
+	if !foundation.FileExists(*helmRepositoryCredentialsPath) {
+		return nil, fmt.Errorf("credentials of type helm-repository are not injected; configure this extension as trusted and inject credentials of type helm-repository")
+	}
+
+	log.Info().Msgf("Reading credentials from file at path %v...", *helmRepositoryCredentialsPath)
+	err = readCredentialsFile(*helmRepositoryCredentialsPath, &credentials)
+	return
+}
+
+func readCredentialsFile(path string, credentials interface{}) error {
+	credentialsFileContent, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed reading credential file at path %v: %w", path, err)
+	}
+	err = json.Unmarshal(credentialsFileContent, credentials)
+	if err != nil {
+		return fmt.Errorf("failed unmarshalling injected credentials: %w", err)
+	}
+	return nil
+}
+
+// initKubectl configures kubectl and helm for the cluster of the credential, with the provider for the type of the credential
+func initKubectl(ctx context.Context, runner CommandRunner, params params) error {
+
+	log.Info().Msgf("Checking if credential %v exists...", params.Credentials)
+	credential, err := findClusterCredential(params.Credentials)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Configuring kubectl for credential %v of type %v...", credential.Name, credential.Type)
+	return clusterProviders[credential.Type].InitKubectl(ctx, runner, params, *credential)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// repositoryAuth contains the username and password to pass to helm for a dependency repository
+type repositoryAuth struct {
+	Username string
+	Password string
+}
+
+// resolveRepositoryAuth returns the username and password for the injected credential mapped to the repository url with dependencyCredentials, or nil if the repository has no credential mapped to it; the password is masked if a command ever prints it
+func resolveRepositoryAuth(ctx context.Context, url string, mappings []dependencyCredential) (*repositoryAuth, error) {
+	auth, err := findRepositoryAuth(ctx, url, mappings)
+	if auth != nil {
+		secretMasker.AddSecrets(auth.Password)
+	}
+	return auth, err
+}
+
+func findRepositoryAuth(ctx context.Context, url string, mappings []dependencyCredential) (*repositoryAuth, error) {
+
+	var mapping *dependencyCredential
+	for i, m := range mappings {
+		prefix := strings.TrimSuffix(m.Repository, "/")
+		if prefix == "" || !matchesRepositoryPrefix(url, prefix) {
+			continue
+		}
+		if mapping == nil || len(prefix) > len(strings.TrimSuffix(mapping.Repository, "/")) {
+			mapping = &mappings[i]
+		}
+	}
+	if mapping == nil {
+		return nil, nil
+	}
+
+	log.Info().Msgf("Using credential %v for repository %v...", mapping.Credentials, url)
+
+	// credentials of type helm-repository take precedence over kubernetes-engine credentials with the same name
+	if helmRepositoryCredentials, err := readHelmRepositoryCredentials(); err == nil {
+		if credential := GetHelmRepositoryCredentialsByName(helmRepositoryCredentials, mapping.Credentials); credential != nil {
+			properties := credential.AdditionalProperties
+			if properties.Token != "" {
+				// helm only supports basic auth, so the token is passed as password like most registries expect
+				username := properties.Username
+				if username == "" {
+					username = "token"
+				}
+				return &repositoryAuth{Username: username, Password: properties.Token}, nil
+			}
+			if properties.Username == "" || properties.Password == "" {
+				return nil, fmt.Errorf("credential %v has no token and no username and password", mapping.Credentials)
+			}
+			return &repositoryAuth{Username: properties.Username, Password: properties.Password}, nil
+		}
+	}
+
+	if gkeCredentials, err := readGKECredentials(); err == nil {
+		if credential := GetCredentialsByName(gkeCredentials, mapping.Credentials); credential != nil {
+			if err := checkImpersonation(credential); err != nil {
+				return nil, err
+			}
+			if credential.AdditionalProperties.ServiceAccountKeyfile == "" {
+				token, err := requestAmbientAccessToken(ctx, credential.Name, credential.AdditionalProperties)
+				if err != nil {
+					return nil, err
+				}
+				// google artifact registry accepts a short-lived access token as password for user oauth2accesstoken
+				return &repositoryAuth{Username: "oauth2accesstoken", Password: token.Token}, nil
+			}
+			// google artifact registry and container registry accept the service account keyfile as password for user _json_key
+			return &repositoryAuth{Username: "_json_key", Password: credential.AdditionalProperties.ServiceAccountKeyfile}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("credential with name %v for repository %v does not exist in the injected credentials of type helm-repository or kubernetes-engine", mapping.Credentials, url)
+}
+
+// matchesRepositoryPrefix returns true if the url is the prefix or below it, so a prefix like https://charts.example.com doesn't match https://charts.example.com.evil.io
+func matchesRepositoryPrefix(url, prefix string) bool {
+	return url == prefix || strings.HasPrefix(url, prefix+"/")
+}
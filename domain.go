@@ -1,33 +1,88 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v2"
+)
 
 type params struct {
-	Action                       string `json:"action,omitempty" yaml:"action,omitempty"`
-	AppVersion                   string `json:"appVersion,omitempty" yaml:"appVersion,omitempty"`
-	Chart                        string `json:"chart,omitempty" yaml:"chart,omitempty"`
-	Credentials                  string `json:"credentials,omitempty" yaml:"credentials,omitempty"`
-	FollowLogs                   bool   `json:"followLogs,omitempty" yaml:"followLogs,omitempty"`
-	Force                        bool   `json:"force,omitempty" yaml:"force,omitempty"`
-	HelmSubdirectory             string `json:"helmSubdir,omitempty" yaml:"helmSubdir,omitempty"`
-	KindHost                     string `json:"kindHost,omitempty" yaml:"kindHost,omitempty"`
-	LabelSelectorOverride        string `json:"labelSelector,omitempty" yaml:"labelSelector,omitempty"`
-	Namespace                    string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
-	ReleaseName                  string `json:"release,omitempty" yaml:"release,omitempty"`
-	RepositoryDirectory          string `json:"repoDir,omitempty" yaml:"repoDir,omitempty"`
-	RepositoryChartsSubdirectory string `json:"repoChartsSubdir,omitempty" yaml:"repoChartsSubdir,omitempty"`
-	RepositoryURL                string `json:"repoUrl,omitempty" yaml:"repoUrl,omitempty"`
-	RepositoryBranch             string `json:"repoBranch,omitempty" yaml:"repoBranch,omitempty"`
-	Bucket                       string `json:"bucket,omitempty" yaml:"bucket,omitempty"`
-	Timeout                      string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
-	Values                       string `json:"values,omitempty" yaml:"values,omitempty"`
-	ValuesFile                   string `json:"valuesFile,omitempty" yaml:"valuesFile,omitempty"`
-	Version                      string `json:"version,omitempty" yaml:"version,omitempty"`
+	Action                       string                 `json:"action,omitempty" yaml:"action,omitempty"`
+	Actions                      []string               `json:"actions,omitempty" yaml:"actions,omitempty"`
+	AllowOverwrite               bool                   `json:"allowOverwrite,omitempty" yaml:"allowOverwrite,omitempty"`
+	AppVersion                   string                 `json:"appVersion,omitempty" yaml:"appVersion,omitempty"`
+	Chart                        string                 `json:"chart,omitempty" yaml:"chart,omitempty"`
+	ChartMuseumURL               string                 `json:"chartMuseumUrl,omitempty" yaml:"chartMuseumUrl,omitempty"`
+	ChartMuseumCredentials       string                 `json:"chartMuseumCredentials,omitempty" yaml:"chartMuseumCredentials,omitempty"`
+	ChartMuseumOnConflict        string                 `json:"chartMuseumOnConflict,omitempty" yaml:"chartMuseumOnConflict,omitempty"`
+	Cosign                       bool                   `json:"cosign,omitempty" yaml:"cosign,omitempty"`
+	CosignKey                    string                 `json:"cosignKey,omitempty" yaml:"cosignKey,omitempty"`
+	Credentials                  string                 `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+	DependencyCredentials        []dependencyCredential `json:"dependencyCredentials,omitempty" yaml:"dependencyCredentials,omitempty"`
+	DryRun                       bool                   `json:"dryRun,omitempty" yaml:"dryRun,omitempty"`
+	FollowLogs                   bool                   `json:"followLogs,omitempty" yaml:"followLogs,omitempty"`
+	Force                        bool                   `json:"force,omitempty" yaml:"force,omitempty"`
+	HelmSubdirectory             string                 `json:"helmSubdir,omitempty" yaml:"helmSubdir,omitempty"`
+	KindHost                     string                 `json:"kindHost,omitempty" yaml:"kindHost,omitempty"`
+	LabelSelectorOverride        string                 `json:"labelSelector,omitempty" yaml:"labelSelector,omitempty"`
+	MaskKeys                     []string               `json:"maskKeys,omitempty" yaml:"maskKeys,omitempty"`
+	Namespace                    string                 `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	PurgeKeepReleases            int                    `json:"purgeKeepReleases,omitempty" yaml:"purgeKeepReleases,omitempty"`
+	PurgePinned                  []string               `json:"purgePinned,omitempty" yaml:"purgePinned,omitempty"`
+	PurgePrereleaseDays          int                    `json:"purgePrereleaseDays,omitempty" yaml:"purgePrereleaseDays,omitempty"`
+	ReleaseName                  string                 `json:"release,omitempty" yaml:"release,omitempty"`
+	RepositoryDirectory          string                 `json:"repoDir,omitempty" yaml:"repoDir,omitempty"`
+	RepositoryChartsSubdirectory string                 `json:"repoChartsSubdir,omitempty" yaml:"repoChartsSubdir,omitempty"`
+	RepositoryURL                string                 `json:"repoUrl,omitempty" yaml:"repoUrl,omitempty"`
+	RepositoryBranch             string                 `json:"repoBranch,omitempty" yaml:"repoBranch,omitempty"`
+	RepositoryPushAttempts       int                    `json:"repoPushAttempts,omitempty" yaml:"repoPushAttempts,omitempty"`
+	SigningCredentials           string                 `json:"signingCredentials,omitempty" yaml:"signingCredentials,omitempty"`
+	SopsAgeKey                   string                 `json:"sopsAgeKey,omitempty" yaml:"sopsAgeKey,omitempty"`
+	Bucket                       string                 `json:"bucket,omitempty" yaml:"bucket,omitempty"`
+	Registry                     string                 `json:"registry,omitempty" yaml:"registry,omitempty"`
+	RegistryCredentials          string                 `json:"registryCredentials,omitempty" yaml:"registryCredentials,omitempty"`
+	TargetValuesFile             string                 `json:"targetValuesFile,omitempty" yaml:"targetValuesFile,omitempty"`
+	TemplateValues               bool                   `json:"templateValues,omitempty" yaml:"templateValues,omitempty"`
+	Timeout                      string                 `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	Values                       string                 `json:"values,omitempty" yaml:"values,omitempty"`
+	ValuesFile                   string                 `json:"valuesFile,omitempty" yaml:"valuesFile,omitempty"`
+	ValuesSources                []valuesSource         `json:"valuesSources,omitempty" yaml:"valuesSources,omitempty"`
+	Verify                       bool                   `json:"verify,omitempty" yaml:"verify,omitempty"`
+	Version                      string                 `json:"version,omitempty" yaml:"version,omitempty"`
+
+	// Build and ReleaseTargetName are set from the estafette environment variables instead of the parameters
+	Build             buildInfo `json:"-" yaml:"-"`
+	ReleaseTargetName string    `json:"-" yaml:"-"`
+}
+
+// UnmarshalYAML allows the action parameter to contain a list of actions, as an alternative to the actions parameter
+func (p *params) UnmarshalYAML(unmarshal func(interface{}) error) error {
+
+	var raw yaml.MapSlice
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+
+	for i, item := range raw {
+		if item.Key == "action" {
+			if _, isList := item.Value.([]interface{}); isList {
+				raw[i].Key = "actions"
+			}
+		}
+	}
+
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return err
+	}
+
+	type plainParams params
+	return yaml.Unmarshal(data, (*plainParams)(p))
 }
 
 func (p *params) SetDefaults(gitName string, appLabel string, buildVersion string, releaseTargetName string, releaseAction string) {
 
-	if p.Action == "" && releaseAction != "" {
+	if p.Action == "" && len(p.Actions) == 0 && releaseAction != "" {
 		p.Action = releaseAction
 	}
 
@@ -60,6 +115,11 @@ func (p *params) SetDefaults(gitName string, appLabel string, buildVersion strin
 		p.HelmSubdirectory = "helm"
 	}
 
+	if p.TargetValuesFile == "" {
+		p.TargetValuesFile = "values-{target}.yaml"
+	}
+	p.ReleaseTargetName = releaseTargetName
+
 	if p.RepositoryDirectory == "" {
 		p.RepositoryDirectory = "helm-charts"
 	}
@@ -72,10 +132,18 @@ func (p *params) SetDefaults(gitName string, appLabel string, buildVersion strin
 		p.RepositoryURL = "https://helm.estafette.io/"
 	}
 
+	if p.ChartMuseumOnConflict == "" {
+		p.ChartMuseumOnConflict = "fail"
+	}
+
 	if p.RepositoryBranch == "" {
 		p.RepositoryBranch = "master"
 	}
 
+	if p.RepositoryPushAttempts == 0 {
+		p.RepositoryPushAttempts = 5
+	}
+
 	if p.ReleaseName == "" {
 		p.ReleaseName = p.Chart
 	}
@@ -86,6 +154,109 @@ func (p *params) SetDefaults(gitName string, appLabel string, buildVersion strin
 	}
 }
 
+// PublishTargets returns the keys of the parameters selecting where the publish action pushes the chart to instead of the git repository
+func (p *params) PublishTargets() (targets []string) {
+	if p.Bucket != "" {
+		targets = append(targets, "bucket")
+	}
+	if p.Registry != "" {
+		targets = append(targets, "registry")
+	}
+	if p.ChartMuseumURL != "" {
+		targets = append(targets, "chartMuseumUrl")
+	}
+	return
+}
+
+// Steps returns the names of the actions to run in order
+func (p *params) Steps() []string {
+	if len(p.Actions) > 0 {
+		return p.Actions
+	}
+	if p.Action != "" {
+		return []string{p.Action}
+	}
+	return nil
+}
+
+// LabelSelector returns the selector for the pods of the release, used to show their logs
+func (p *params) LabelSelector() string {
+	if p.LabelSelectorOverride != "" {
+		return p.LabelSelectorOverride
+	}
+	return fmt.Sprintf("app.kubernetes.io/instance=%v", p.ReleaseName)
+}
+
+// globalParamKeys are the parameters used by every action
+var globalParamKeys = []string{"action", "actions", "dryRun", "maskKeys"}
+
+// paramDescription documents a single parameter for the README and help text
+type paramDescription struct {
+	Key         string
+	Description string
+}
+
+// paramDescriptions documents all parameters in the order they're listed in the README
+var paramDescriptions = []paramDescription{
+	{Key: "action", Description: "Determines the action taken by the extension; valid options are %v"},
+	{Key: "actions", Description: "Actions to run in order within a single stage with the same parameters, stopping at the first failure; `action` accepts a list as well"},
+	{Key: "allowOverwrite", Description: "Allows publishing a version that is already published with a different digest, replacing its contents; publishing the exact same package again always succeeds without changes"},
+	{Key: "appVersion", Description: "Can be used to override the app version; defaults to `$ESTAFETTE_BUILD_VERSION`"},
+	{Key: "bucket", Description: "The gcs bucket to publish the chart to or purge it from instead of a git repository; uses the `credentials` to authenticate and cannot be combined with `registry` or `chartMuseumUrl`"},
+	{Key: "chart", Description: "The name of the chart and subdirectory where the chart is stored; defaults to `$ESTAFETTE_LABEL_APP` or `$ESTAFETTE_GIT_NAME` in that order"},
+	{Key: "chartMuseumUrl", Description: "The url of a ChartMuseum compatible repository to upload the chart and its provenance file to instead of a git repository; cannot be combined with `bucket` or `registry`"},
+	{Key: "chartMuseumCredentials", Description: "The name of an injected credential of type `helm-repository` to authenticate to `chartMuseumUrl` with, using basic auth or its token as bearer token"},
+	{Key: "chartMuseumOnConflict", Description: "What to do if the chart version already exists in `chartMuseumUrl`; valid options are `fail`, `skip` or `overwrite`; defaults to `fail`"},
+	{Key: "cosign", Description: "Signs the chart published to `registry` with cosign and attaches an in-toto attestation recording the build version, git revision and chart digest; signs keyless with the identity of the pipeline unless `cosignKey` is set"},
+	{Key: "cosignKey", Description: "The cosign key to sign with when `cosign` is set, as a path to a key file or a kms uri like `gcpkms://projects/...`; the password of a key file is read from `$COSIGN_PASSWORD`"},
+	{Key: "credentials", Description: "To set a specific set of credentials; of type `kubernetes-engine` for gcs buckets and registries, or of type `kubernetes-engine`, `kubernetes-config`, `kubernetes-token` or `kubernetes-in-cluster` for the cluster to diff, install or uninstall in; defaults to the release target name prefixed with `gke-`"},
+	{Key: "dependencyCredentials", Description: "Maps dependency repository urls to the name of an injected credential of type `helm-repository` or `kubernetes-engine`, to add private repositories and log in to `oci://` registries; each item has a `repository` url prefix and a `credentials` name"},
+	{Key: "dryRun", Description: "Prints the commands the actions would run instead of running them, after resolving parameters, credentials, values and chart file; can be set with the `--dry-run` flag as well"},
+	{Key: "followLogs", Description: "Indicate whether to follow logs after installing a chart; use it for jobs, but not for deployments since pods will continue to run"},
+	{Key: "force", Description: "Allow a force installation for action `install`"},
+	{Key: "helmSubdir", Description: "The subdirectory in this repository where helm charts are stores; defaults to `helm`"},
+	{Key: "kindHost", Description: "The service container name running the [bsycorp/kind](https://hub.docker.com/r/bsycorp/kind) container to run tests against; defaults to `kubernetes`"},
+	{Key: "labelSelector", Description: "The label selector to show logs for after installing; defaults to `app.kubernetes.io/instance=<release>`"},
+	{Key: "maskKeys", Description: "Regular expressions for keys of values to mask in the build log, next to the default ones for keys ending in `password`, `secret`, `secretKey`, `token`, `apiKey`, `privateKey` or `credentials`; keys referring to a secret by name, like `existingSecret`, `secretName` or ending in `Ref`, are never masked"},
+	{Key: "namespace", Description: "The namespace to deploy to"},
+	{Key: "purgeKeepReleases", Description: "Makes `purge` keep only the newest number of releases per major and minor version, removing the older ones; all releases are kept if not set"},
+	{Key: "purgePinned", Description: "Versions or glob patterns like `1.2.*` that `purge` never removes"},
+	{Key: "purgePrereleaseDays", Description: "Makes `purge` remove all pre-release versions published more than this number of days ago, next to the pre-releases of the version being released"},
+	{Key: "registry", Description: "The oci registry url like `oci://europe-docker.pkg.dev/my-project/charts` to publish the chart to instead of a git repository; cannot be combined with `bucket` or `chartMuseumUrl`"},
+	{Key: "registryCredentials", Description: "The name of an injected credential of type `helm-repository` or `kubernetes-engine` to log in to the `registry` with; no login happens if not set"},
+	{Key: "release", Description: "Name for the Helm release; defaults to the `chart` name"},
+	{Key: "repoDir", Description: "The directory into which the chart repository is cloned; defaults to `helm-charts`"},
+	{Key: "repoChartsSubdir", Description: "The subdirectory of the chart repository into which the tgz files are copied; defaults to `charts`"},
+	{Key: "repoUrl", Description: "The full url towards the helm repository, to be used to generate the `index.yaml` file and fetch charts from; defaults to `https://helm.estafette.io/`"},
+	{Key: "repoBranch", Description: "The branch of the chart repository to push to; defaults to `master`"},
+	{Key: "repoPushAttempts", Description: "How many times to push to the chart repository when the push is rejected because another pipeline pushed to the branch first; the changes are applied on top of the moved branch again before every retry; defaults to `5`"},
+	{Key: "signingCredentials", Description: "The name of an injected credential of type `helm-signing-key` to sign the chart with when packaging, creating a `.prov` provenance file, or to verify the chart with when `verify` is set"},
+	{Key: "sopsAgeKey", Description: "The age private key to decrypt sops encrypted values files with, preferably set as an estafette secret; files encrypted with gcp kms are decrypted with the service account of the `credentials` instead, in the diff and install actions only"},
+	{Key: "targetValuesFile", Description: "The values file in the chart directory `<helmSubdir>/<chart>` to merge under all other values when running in a release target, with `{target}` replaced by the release target name; it's skipped if it doesn't exist, or if set to `none`; defaults to `values-{target}.yaml`"},
+	{Key: "templateValues", Description: "Expands `values`, values files and the inline values of `valuesSources` as go templates, with `{{ .Build.Version }}`, `{{ .Build.GitRevision }}`, `{{ .Build.GitRepository }}`, `{{ .Chart.Name }}`, `{{ .Chart.Version }}`, `{{ .Chart.AppVersion }}`, `{{ .Release.Name }}`, `{{ .Release.Namespace }}` and `{{ .Release.Target }}`; referring to anything else fails"},
+	{Key: "timeout", Description: "The time with units to wait for an install to finish; defaults to `300s`"},
+	{Key: "values", Description: "Contents of a values.yaml file to use with the install command in order to set required values; merged on top of `valuesFile`"},
+	{Key: "valuesFile", Description: "Path to a values.yaml file to use with the install command"},
+	{Key: "valuesSources", Description: "Values sources merged in order on top of `valuesFile` and `values`, like helm's `-f a.yaml -f b.yaml --set key=value`; each item has either a `file` path, inline `values` yaml or a `set` map of dotted keys like `image.tag` to values"},
+	{Key: "verify", Description: "Verifies the provenance of the chart with the public keyring of the `signingCredentials` before diffing or installing it"},
+	{Key: "version", Description: "Can be used to override the package version; defaults to `$ESTAFETTE_BUILD_VERSION`"},
+}
+
+// dependencyCredential selects the injected credential to use for dependency repositories with urls starting with the repository value
+type dependencyCredential struct {
+	Repository  string `json:"repository,omitempty" yaml:"repository,omitempty"`
+	Credentials string `json:"credentials,omitempty" yaml:"credentials,omitempty"`
+}
+
+// chartMetadata contains the fields of a Chart.yaml file needed by the actions; Helm 3 charts with apiVersion v2 list their dependencies here
+type chartMetadata struct {
+	APIVersion   string       `json:"apiVersion,omitempty" yaml:"apiVersion,omitempty"`
+	Name         string       `json:"name,omitempty" yaml:"name,omitempty"`
+	Version      string       `json:"version,omitempty" yaml:"version,omitempty"`
+	Dependencies []dependency `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
+}
+
+// requirements contains the dependencies of a Helm 2 chart from its requirements.yaml file
 type requirements struct {
 	Dependencies []dependency `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
 }
@@ -26,6 +26,41 @@ values: |-
 			assert.Equal(t, "secret:\n  letsencryptAccountJson='{}'\n  letsencryptAccountKey=abc", params.Values)
 		}
 	})
+
+	t.Run("ReturnsActionsIfActionContainsList", func(t *testing.T) {
+
+		customProperties := `
+action:
+- lint
+- package
+chart: mychart
+`
+
+		// act
+		var params params
+		err := yaml.Unmarshal([]byte(customProperties), &params)
+
+		if assert.Nil(t, err) {
+			assert.Equal(t, "", params.Action)
+			assert.Equal(t, []string{"lint", "package"}, params.Actions)
+			assert.Equal(t, "mychart", params.Chart)
+		}
+	})
+
+	t.Run("ReturnsActionsIfActionsIsSet", func(t *testing.T) {
+
+		customProperties := `
+actions: [lint, package, test, publish]
+`
+
+		// act
+		var params params
+		err := yaml.Unmarshal([]byte(customProperties), &params)
+
+		if assert.Nil(t, err) {
+			assert.Equal(t, []string{"lint", "package", "test", "publish"}, params.Actions)
+		}
+	})
 }
 
 func TestSetDefaults(t *testing.T) {
@@ -480,4 +515,40 @@ func TestSetDefaults(t *testing.T) {
 
 		assert.Equal(t, "install", params.Action)
 	})
+
+	t.Run("KeepsActionEmptyIfActionsAreSet", func(t *testing.T) {
+
+		gitName := "git-name"
+		appLabel := "app-label"
+		buildVersion := "1.0.0"
+		releaseTargetName := "development"
+		releaseAction := "diff"
+
+		params := params{
+			Actions: []string{"diff", "install"},
+		}
+
+		// act
+		params.SetDefaults(gitName, appLabel, buildVersion, releaseTargetName, releaseAction)
+
+		assert.Equal(t, "", params.Action)
+		assert.Equal(t, []string{"diff", "install"}, params.Steps())
+	})
+
+	t.Run("SetsTargetValuesFileConventionAndReleaseTargetName", func(t *testing.T) {
+
+		gitName := "git-name"
+		appLabel := "app-label"
+		buildVersion := "1.0.0"
+		releaseTargetName := "production"
+		releaseAction := ""
+
+		params := params{}
+
+		// act
+		params.SetDefaults(gitName, appLabel, buildVersion, releaseTargetName, releaseAction)
+
+		assert.Equal(t, "values-{target}.yaml", params.TargetValuesFile)
+		assert.Equal(t, "production", params.ReleaseTargetName)
+	})
 }
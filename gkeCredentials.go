@@ -1,5 +1,7 @@
 package main
 
+import "fmt"
+
 // GKECredentials represents the credentials of type kubernetes-engine as defined in the server config and passed to this trusted image
 type GKECredentials struct {
 	Name                 string                            `json:"name,omitempty"`
@@ -14,6 +16,10 @@ type GKECredentialAdditionalProperties struct {
 	Region                string `json:"region,omitempty"`
 	Zone                  string `json:"zone,omitempty"`
 	ServiceAccountKeyfile string `json:"serviceAccountKeyfile,omitempty"`
+	// without keyfile the token file is exchanged with the workload identity provider, or the metadata server is used if there's no token file either
+	WorkloadIdentityProvider  string `json:"workloadIdentityProvider,omitempty"`
+	TokenFile                 string `json:"tokenFile,omitempty"`
+	ImpersonateServiceAccount string `json:"impersonateServiceAccount,omitempty"`
 }
 
 // GetCredentialsByName returns a credential if the name exists
@@ -27,3 +33,11 @@ func GetCredentialsByName(c []GKECredentials, credentialName string) *GKECredent
 
 	return nil
 }
+
+// checkImpersonation returns an error if a credential with keyfile sets impersonateServiceAccount, because the keyfile is handed as is to the helm gcs plugin, sops and registry logins, which can't impersonate with it
+func checkImpersonation(credential *GKECredentials) error {
+	if credential.AdditionalProperties.ServiceAccountKeyfile != "" && credential.AdditionalProperties.ImpersonateServiceAccount != "" {
+		return fmt.Errorf("credential %v has both a serviceAccountKeyfile and impersonateServiceAccount; impersonation is only supported for credentials without keyfile", credential.Name)
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+// helmArgs builds the argument list for a helm or kubectl command, so values are passed as is instead of being interpolated in a command string
+type helmArgs struct {
+	args []string
+}
+
+// newHelmArgs starts the argument list with the (sub)command and positional arguments
+func newHelmArgs(args ...string) *helmArgs {
+	return &helmArgs{args: append([]string{}, args...)}
+}
+
+// Arg adds positional arguments
+func (h *helmArgs) Arg(args ...string) *helmArgs {
+	h.args = append(h.args, args...)
+	return h
+}
+
+// Flag adds the flag followed by its value, unless the value is empty
+func (h *helmArgs) Flag(name, value string) *helmArgs {
+	if value != "" {
+		h.args = append(h.args, name, value)
+	}
+	return h
+}
+
+// BoolFlag adds the flag without value if enabled is true
+func (h *helmArgs) BoolFlag(name string, enabled bool) *helmArgs {
+	if enabled {
+		h.args = append(h.args, name)
+	}
+	return h
+}
+
+// ValuesStdin adds -f - to read the values from stdin if there are any, so they're passed to helm without writing them to disk
+func (h *helmArgs) ValuesStdin(values string) *helmArgs {
+	if values != "" {
+		h.Flag("-f", "-")
+	}
+	return h
+}
+
+// Args returns the argument list to pass to CommandRunner.RunCommandWithArgs
+func (h *helmArgs) Args() []string {
+	return h.args
+}
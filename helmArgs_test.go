@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHelmArgs(t *testing.T) {
+	t.Run("SkipsFlagsWithoutValue", func(t *testing.T) {
+
+		// act
+		args := newHelmArgs("uninstall", "myrelease").
+			Flag("--namespace", "").
+			Flag("--timeout", "300s").
+			Args()
+
+		assert.Equal(t, []string{"uninstall", "myrelease", "--timeout", "300s"}, args)
+	})
+
+	t.Run("AddsBoolFlagsOnlyIfEnabled", func(t *testing.T) {
+
+		// act
+		args := newHelmArgs("upgrade").
+			BoolFlag("--atomic", true).
+			BoolFlag("--force", false).
+			Args()
+
+		assert.Equal(t, []string{"upgrade", "--atomic"}, args)
+	})
+
+	t.Run("ReadsValuesFromStdinOnlyIfThereAreValues", func(t *testing.T) {
+
+		// act
+		withValues := newHelmArgs("diff", "upgrade").ValuesStdin("replicas: 3\n").Args()
+		withoutValues := newHelmArgs("diff", "upgrade").ValuesStdin("").Args()
+
+		assert.Equal(t, []string{"diff", "upgrade", "-f", "-"}, withValues)
+		assert.Equal(t, []string{"diff", "upgrade"}, withoutValues)
+	})
+
+	t.Run("KeepsValuesWithShellMetacharactersAsSingleArgument", func(t *testing.T) {
+
+		// act
+		args := newHelmArgs("fetch", "my chart; rm -rf /").
+			Flag("--version", "$(whoami)").
+			Args()
+
+		assert.Equal(t, []string{"fetch", "my chart; rm -rf /", "--version", "$(whoami)"}, args)
+	})
+}
@@ -0,0 +1,27 @@
+package main
+
+// HelmRepositoryCredentials represents the credentials of type helm-repository as defined in the server config and passed to this trusted image
+type HelmRepositoryCredentials struct {
+	Name                 string                                       `json:"name,omitempty"`
+	Type                 string                                       `json:"type,omitempty"`
+	AdditionalProperties HelmRepositoryCredentialAdditionalProperties `json:"additionalProperties,omitempty"`
+}
+
+// HelmRepositoryCredentialAdditionalProperties contains the non standard fields for this type of credentials; either username and password or a token are set
+type HelmRepositoryCredentialAdditionalProperties struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// GetHelmRepositoryCredentialsByName returns a credential if the name exists
+func GetHelmRepositoryCredentialsByName(c []HelmRepositoryCredentials, credentialName string) *HelmRepositoryCredentials {
+
+	for _, cred := range c {
+		if cred.Name == credentialName {
+			return &cred
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+// HelmSigningKeyCredentials represents the credentials of type helm-signing-key as defined in the server config and passed to this trusted image
+type HelmSigningKeyCredentials struct {
+	Name                 string                                       `json:"name,omitempty"`
+	Type                 string                                       `json:"type,omitempty"`
+	AdditionalProperties HelmSigningKeyCredentialAdditionalProperties `json:"additionalProperties,omitempty"`
+}
+
+// HelmSigningKeyCredentialAdditionalProperties contains the non standard fields for this type of credentials; the keyrings are base64 encoded binary gpg keyrings as helm doesn't support the kbx format
+type HelmSigningKeyCredentialAdditionalProperties struct {
+	KeyName       string `json:"keyName,omitempty"`
+	Keyring       string `json:"keyring,omitempty"`
+	PublicKeyring string `json:"publicKeyring,omitempty"`
+	Passphrase    string `json:"passphrase,omitempty"`
+}
+
+// GetHelmSigningKeyCredentialsByName returns a credential if the name exists
+func GetHelmSigningKeyCredentialsByName(c []HelmSigningKeyCredentials, credentialName string) *HelmSigningKeyCredentials {
+
+	for _, cred := range c {
+		if cred.Name == credentialName {
+			return &cred
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+type diffAction struct{}
+
+func (diffAction) Name() string {
+	return "diff"
+}
+
+func (diffAction) Description() string {
+	return "Shows the changes an install would make to the release in a kubernetes cluster"
+}
+
+func (diffAction) RequiredParams() []string {
+	return []string{"chart", "version", "credentials", "namespace", "release"}
+}
+
+func (diffAction) OptionalParams() []string {
+	return []string{"appVersion", "helmSubdir", "repoUrl", "signingCredentials", "sopsAgeKey", "targetValuesFile", "templateValues", "values", "valuesFile", "valuesSources", "verify"}
+}
+
+func (diffAction) Run(ctx context.Context, runner CommandRunner, params params) error {
+	_, _, err := diffRelease(ctx, runner, params)
+	return err
+}
+
+type installAction struct{}
+
+func (installAction) Name() string {
+	return "install"
+}
+
+func (installAction) Description() string {
+	return "Installs or upgrades the release in a kubernetes cluster"
+}
+
+func (installAction) RequiredParams() []string {
+	return []string{"chart", "version", "credentials", "namespace", "release", "timeout"}
+}
+
+func (installAction) OptionalParams() []string {
+	return []string{"appVersion", "followLogs", "force", "helmSubdir", "labelSelector", "repoUrl", "signingCredentials", "sopsAgeKey", "targetValuesFile", "templateValues", "values", "valuesFile", "valuesSources", "verify"}
+}
+
+func (installAction) Run(ctx context.Context, runner CommandRunner, params params) error {
+	filename, mergedValues, err := diffRelease(ctx, runner, params)
+	if err != nil {
+		return err
+	}
+
+	labelSelector := params.LabelSelector()
+
+	log.Printf("\nInstalling chart and waiting for %v for it to be ready...\n", params.Timeout)
+	upgradeArgs := newHelmArgs("upgrade", "--install", params.ReleaseName, filename).
+		ValuesStdin(mergedValues).
+		Flag("--namespace", params.Namespace).
+		Flag("--history-max", "1").
+		BoolFlag("--cleanup-on-fail", true).
+		BoolFlag("--atomic", true).
+		Flag("--timeout", params.Timeout).
+		BoolFlag("--force", params.Force).
+		BoolFlag("--create-namespace", true)
+	err = runHelmWithValues(ctx, runner, upgradeArgs.Args(), mergedValues)
+	if err != nil {
+		log.Printf("Installation failed, showing logs...")
+		_ = runner.RunCommandWithArgs(ctx, "kubectl", []string{"get", "all,secret", "-n", params.Namespace})
+		_ = runner.RunCommandWithArgs(ctx, "kubectl", []string{"logs", "-l", labelSelector, "-n", params.Namespace, "--all-containers=true"})
+		return fmt.Errorf("installing release %v failed: %w", params.ReleaseName, err)
+	}
+
+	log.Info().Msg("Showing logs for container...")
+	logsArgs := newHelmArgs("logs", "-l", labelSelector, "-n", params.Namespace, "--all-containers=true", "--pod-running-timeout=60s").
+		BoolFlag("--follow=true", params.FollowLogs)
+	_ = runner.RunCommandWithArgs(ctx, "kubectl", logsArgs.Args())
+
+	return nil
+}
+
+// diffRelease prepares kubectl, the chart and values for the release and shows the changes an install would make; it returns the chart filename and merged values for the install
+func diffRelease(ctx context.Context, runner CommandRunner, params params) (filename string, mergedValues string, err error) {
+	log.Info().Msgf("Installing chart %v with app version %v and version %v...", params.Chart, params.AppVersion, params.Version)
+
+	err = initKubectl(ctx, runner, params)
+	if err != nil {
+		return
+	}
+
+	mergedValues, err = prepareValues(ctx, runner, params)
+	if err != nil {
+		return
+	}
+
+	var key *signingKey
+	if params.Verify {
+		key, err = initSigningKey(params)
+		if err != nil {
+			return
+		}
+	}
+
+	filename = fmt.Sprintf("%v-%v.tgz", params.Chart, params.Version)
+	if !foundation.FileExists(filename) {
+		log.Info().Msgf("No helm package present, retrieving helm chart %v version %v from %v...", params.Chart, params.Version, params.RepositoryURL)
+		fetchArgs := newHelmArgs("fetch", params.Chart, "--version", params.Version, "--repo", params.RepositoryURL)
+		if key != nil {
+			fetchArgs.BoolFlag("--verify", true).Flag("--keyring", key.VerificationKeyring)
+		}
+		err = runner.RunCommandWithArgs(ctx, "helm", fetchArgs.Args())
+		if err != nil {
+			return
+		}
+	} else if key != nil {
+		err = verifyChart(ctx, runner, key, filename)
+		if err != nil {
+			return
+		}
+	}
+
+	log.Info().Msg("Showing template to be installed...")
+	diffArgs := newHelmArgs("diff", "upgrade", params.ReleaseName, filename).
+		ValuesStdin(mergedValues).
+		Flag("--namespace", params.Namespace).
+		BoolFlag("--allow-unreleased", true)
+	err = runHelmWithValues(ctx, runner, diffArgs.Args(), mergedValues)
+
+	return
+}
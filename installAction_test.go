@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstallAction(t *testing.T) {
+	t.Run("FetchesDiffsAndInstallsChartWithForce", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:         "mychart",
+			Version:       "1.0.0",
+			ReleaseName:   "myrelease",
+			Namespace:     "mynamespace",
+			Timeout:       "300s",
+			Force:         true,
+			Credentials:   "gke-production",
+			RepositoryURL: "https://helm.estafette.io/",
+		}
+
+		// act
+		err := installAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"gcloud auth activate-service-account sa@my-project.iam.gserviceaccount.com --key-file " + serviceAccountKeyfilePath,
+			"gcloud config set account sa@my-project.iam.gserviceaccount.com",
+			"gcloud config set project my-project",
+			"gcloud container clusters get-credentials my-cluster --zone europe-west1-c",
+			"helm fetch mychart --version 1.0.0 --repo https://helm.estafette.io/",
+			"helm diff upgrade myrelease mychart-1.0.0.tgz --namespace mynamespace --allow-unreleased",
+			"helm upgrade --install myrelease mychart-1.0.0.tgz --namespace mynamespace --history-max 1 --cleanup-on-fail --atomic --timeout 300s --force --create-namespace",
+			"kubectl logs -l app.kubernetes.io/instance=myrelease -n mynamespace --all-containers=true --pod-running-timeout=60s",
+		}, runner.commandLines())
+	})
+
+	t.Run("UsesLocalPackageAndValuesWithoutForce", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz", []byte{}, 0644)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:       "mychart",
+			Version:     "1.0.0",
+			ReleaseName: "mychart",
+			Namespace:   "mynamespace",
+			Timeout:     "60s",
+			Credentials: "gke-production",
+			Values:      "replicas: 3",
+			FollowLogs:  true,
+		}
+
+		// act
+		err := installAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"helm diff upgrade mychart mychart-1.0.0.tgz -f - --namespace mynamespace --allow-unreleased",
+			"helm upgrade --install mychart mychart-1.0.0.tgz -f - --namespace mynamespace --history-max 1 --cleanup-on-fail --atomic --timeout 60s --create-namespace",
+			"kubectl logs -l app.kubernetes.io/instance=mychart -n mynamespace --all-containers=true --pod-running-timeout=60s --follow=true",
+		}, runner.commandLines()[4:])
+		assert.Equal(t, []string{"replicas: 3\n", "replicas: 3\n"}, runner.inputs[4:6])
+	})
+
+	t.Run("ShowsResourcesAndReturnsErrorIfUpgradeFails", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		runner := &fakeCommandRunner{
+			errors: map[string]error{"helm upgrade": errors.New("timed out")},
+		}
+		params := params{
+			Chart:       "mychart",
+			Version:     "1.0.0",
+			ReleaseName: "mychart",
+			Namespace:   "mynamespace",
+			Timeout:     "300s",
+			Credentials: "gke-production",
+		}
+
+		// act
+		err := installAction{}.Run(context.Background(), runner, params)
+
+		assert.NotNil(t, err)
+		commands := runner.commandLines()
+		assert.Equal(t, []string{
+			"kubectl get all,secret -n mynamespace",
+			"kubectl logs -l app.kubernetes.io/instance=mychart -n mynamespace --all-containers=true",
+		}, commands[len(commands)-2:])
+	})
+
+	t.Run("DoesNotWriteFilesInDryRun", func(t *testing.T) {
+
+		dir := inTempDir(t)
+		withCredentials(t)
+		runner := NewDryRunRunner()
+		params := params{
+			Chart:         "mychart",
+			Version:       "1.0.0",
+			ReleaseName:   "myrelease",
+			Namespace:     "mynamespace",
+			Timeout:       "300s",
+			Credentials:   "gke-production",
+			RepositoryURL: "https://helm.estafette.io/",
+			Values:        "replicas: 3",
+			DryRun:        true,
+		}
+
+		// act
+		err := installAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 8, len(runner.commands))
+		assert.Equal(t, "helm upgrade --install myrelease mychart-1.0.0.tgz -f - --namespace mynamespace --history-max 1 --cleanup-on-fail --atomic --timeout 300s --create-namespace <<< '***'", runner.commands[6])
+		assert.False(t, foundation.FileExists(serviceAccountKeyfilePath))
+		files, _ := ioutil.ReadDir(dir)
+		assert.Equal(t, 0, len(files))
+	})
+
+	t.Run("FetchesChartWithVerifyIfVerifyIsSet", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		withSigningCredentials(t)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:              "mychart",
+			Version:            "1.0.0",
+			ReleaseName:        "myrelease",
+			Namespace:          "mynamespace",
+			Timeout:            "300s",
+			Credentials:        "gke-production",
+			RepositoryURL:      "https://helm.estafette.io/",
+			SigningCredentials: "chart-signing",
+			Verify:             true,
+		}
+
+		// act
+		err := installAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "helm fetch mychart --version 1.0.0 --repo https://helm.estafette.io/ --verify --keyring "+verificationKeyringPath, runner.commandLines()[4])
+	})
+
+	t.Run("VerifiesLocalPackageAndStopsIfVerificationFails", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		withSigningCredentials(t)
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz", []byte{}, 0644)
+		runner := &fakeCommandRunner{
+			errors: map[string]error{
+				"helm verify": errors.New("exit status 1"),
+			},
+		}
+		params := params{
+			Chart:              "mychart",
+			Version:            "1.0.0",
+			ReleaseName:        "myrelease",
+			Namespace:          "mynamespace",
+			Timeout:            "300s",
+			Credentials:        "gke-production",
+			SigningCredentials: "chart-signing",
+			Verify:             true,
+		}
+
+		// act
+		err := installAction{}.Run(context.Background(), runner, params)
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "failed verifying provenance of chart mychart-1.0.0.tgz: exit status 1", err.Error())
+		}
+		assert.Equal(t, "helm verify mychart-1.0.0.tgz --keyring "+verificationKeyringPath, runner.commandLines()[len(runner.commands)-1])
+	})
+}
+
+func TestInstallActionArguments(t *testing.T) {
+	t.Run("PassesLabelSelectorAndValuesFileWithSpacesAsSingleArguments", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		_ = ioutil.WriteFile("my values.yaml", []byte("replicas: 3"), 0644)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:                 "mychart",
+			Version:               "1.0.0",
+			ReleaseName:           "mychart",
+			Namespace:             "mynamespace",
+			Timeout:               "300s",
+			Credentials:           "gke-production",
+			ValuesFile:            "my values.yaml",
+			LabelSelectorOverride: "app in (mychart, other)",
+		}
+
+		// act
+		err := installAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"helm", "diff", "upgrade", "mychart", "mychart-1.0.0.tgz", "-f", "-", "--namespace", "mynamespace", "--allow-unreleased"}, runner.commands[5])
+		assert.Equal(t, []string{"kubectl", "logs", "-l", "app in (mychart, other)", "-n", "mynamespace", "--all-containers=true", "--pod-running-timeout=60s"}, runner.commands[7])
+	})
+}
+
+func TestDiffAction(t *testing.T) {
+	t.Run("DoesNotInstallChart", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:         "mychart",
+			Version:       "1.0.0",
+			ReleaseName:   "mychart",
+			Namespace:     "mynamespace",
+			Credentials:   "gke-production",
+			RepositoryURL: "https://helm.estafette.io/",
+		}
+
+		// act
+		err := diffAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		commands := runner.commandLines()
+		assert.Equal(t, "helm diff upgrade mychart mychart-1.0.0.tgz --namespace mynamespace --allow-unreleased", commands[len(commands)-1])
+	})
+
+	t.Run("ReturnsErrorIfCredentialDoesNotExist", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:       "mychart",
+			Version:     "1.0.0",
+			Credentials: "gke-staging",
+		}
+
+		// act
+		err := diffAction{}.Run(context.Background(), runner, params)
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, len(runner.commands))
+	})
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/rs/zerolog/log"
+)
+
+type lintAction struct{}
+
+func (lintAction) Name() string {
+	return "lint"
+}
+
+func (lintAction) Description() string {
+	return "Lints the chart and its subcharts"
+}
+
+func (lintAction) RequiredParams() []string {
+	return []string{"chart", "helmSubdir"}
+}
+
+func (lintAction) OptionalParams() []string {
+	return nil
+}
+
+func (lintAction) Run(ctx context.Context, runner CommandRunner, params params) error {
+	log.Info().Msgf("Linting chart %v...", params.Chart)
+	return runner.RunCommandWithArgs(ctx, "helm", []string{"lint", "--with-subcharts", filepath.Join(params.HelmSubdirectory, params.Chart)})
+}
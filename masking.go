@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultSecretKeyPatterns matches the keys of values that are masked, next to the patterns set with maskKeys
+var defaultSecretKeyPatterns = []string{`(?i)pass(word|wd|phrase)?$`, `(?i)secret(_?(access_?)?key)?$`, `(?i)token$`, `(?i)api_?key$`, `(?i)private_?key$`, `(?i)credentials?$`}
+
+// referenceKeyRegex matches keys that refer to a secret by name instead of holding it, like existingSecret, tls.secretName or passwordSecretRef, which are never masked
+var referenceKeyRegex = regexp.MustCompile(`(?i)(^existing|name$|ref$)`)
+
+// minimumSecretLength avoids masking every occurrence of very short values like 1 or no in the output
+const minimumSecretLength = 4
+
+// secretMasker redacts secrets from the values and command output printed to the build log
+var secretMasker = newOutputMasker(defaultSecretKeyPatterns...)
+
+// outputMasker masks values under secret keys, the secret values it has seen in any output and the data of kubernetes secrets in manifests and diffs
+type outputMasker struct {
+	mutex       sync.RWMutex
+	keyPatterns []*regexp.Regexp
+	secrets     []string
+}
+
+func newOutputMasker(keyPatterns ...string) *outputMasker {
+	m := &outputMasker{}
+	if err := m.AddKeyPatterns(keyPatterns...); err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// AddKeyPatterns adds regular expressions for keys whose values are masked
+func (m *outputMasker) AddKeyPatterns(patterns ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, p := range patterns {
+		r, err := regexp.Compile(p)
+		if err != nil {
+			return fmt.Errorf("failed compiling mask key pattern '%v': %w", p, err)
+		}
+		m.keyPatterns = append(m.keyPatterns, r)
+	}
+	return nil
+}
+
+// AddSecrets adds values to replace by *** wherever they show up in the output
+func (m *outputMasker) AddSecrets(secrets ...string) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, s := range secrets {
+		s = strings.TrimSpace(s)
+		if len(s) < minimumSecretLength {
+			continue
+		}
+		m.secrets = append(m.secrets, s)
+	}
+	// replace longer secrets first, so a secret containing another one is masked completely
+	sort.SliceStable(m.secrets, func(i, j int) bool {
+		return len(m.secrets[i]) > len(m.secrets[j])
+	})
+}
+
+// MaskString replaces all known secrets in s
+func (m *outputMasker) MaskString(s string) string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for _, secret := range m.secrets {
+		s = strings.ReplaceAll(s, secret, "***")
+	}
+	return s
+}
+
+// IsSecretKey returns true if the key matches any of the key patterns and doesn't refer to a secret by name
+func (m *outputMasker) IsSecretKey(key string) bool {
+	if referenceKeyRegex.MatchString(key) {
+		return false
+	}
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	for _, r := range m.keyPatterns {
+		if r.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskValues returns a copy of the values with the scalar values under secret keys and the known secrets replaced by ***; values found by their key aren't added to the known secrets, since a key pattern can match values like names that show up all over the output
+func (m *outputMasker) MaskValues(values map[string]interface{}) map[string]interface{} {
+	masked, _ := m.maskValue(values, false).(map[string]interface{})
+	return masked
+}
+
+// maskValue masks the scalar values whose own key is a secret key, or the items of a list under such a key; maps under a secret key are masked by their own keys
+func (m *outputMasker) maskValue(value interface{}, secretKey bool) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		masked := map[string]interface{}{}
+		for key, item := range v {
+			masked[key] = m.maskValue(item, m.IsSecretKey(key))
+		}
+		return masked
+	case []interface{}:
+		masked := make([]interface{}, len(v))
+		for i, item := range v {
+			masked[i] = m.maskValue(item, secretKey)
+		}
+		return masked
+	case nil:
+		return value
+	case string:
+		if secretKey || m.isSecret(v) {
+			return "***"
+		}
+		return value
+	default:
+		if secretKey {
+			return "***"
+		}
+		return value
+	}
+}
+
+// isSecret returns true if s is one of the known secrets, like a value decrypted from a sops encrypted values file
+func (m *outputMasker) isSecret(s string) bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	s = strings.TrimSpace(s)
+	for _, secret := range m.secrets {
+		if s == secret {
+			return true
+		}
+	}
+	return false
+}
+
+// secretLeaves returns the string values in a value that can be masked in the output; booleans and numbers are left out, also when quoted, since masking every true or 3600 in the output would hide far more than the secret
+func secretLeaves(value interface{}) (leaves []string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, item := range v {
+			leaves = append(leaves, secretLeaves(item)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			leaves = append(leaves, secretLeaves(item)...)
+		}
+	case string:
+		if _, err := strconv.ParseBool(v); err == nil {
+			return
+		}
+		if _, err := strconv.ParseFloat(v, 64); err == nil {
+			return
+		}
+		leaves = append(leaves, v)
+	}
+	return
+}
+
+// leafStrings returns the scalar values in a value as strings
+func leafStrings(value interface{}) (leaves []string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, item := range v {
+			leaves = append(leaves, leafStrings(item)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			leaves = append(leaves, leafStrings(item)...)
+		}
+	case nil:
+	default:
+		leaves = append(leaves, fmt.Sprintf("%v", v))
+	}
+	return
+}
+
+// Writer returns a writer that masks the output line by line before writing it to w; it has to be closed to write the last line if it doesn't end with a newline
+func (m *outputMasker) Writer(w io.Writer) io.WriteCloser {
+	return &maskingWriter{masker: m, out: w, dataIndent: -1}
+}
+
+var (
+	// diffHeaderRegex matches the line helm diff prints before the changes of every resource, like 'default, mysecret, Secret (v1) has changed:'
+	diffHeaderRegex        = regexp.MustCompile(`^\S.*, \S+ \(.*\) has (changed|been added|been removed)`)
+	documentSeparatorRegex = regexp.MustCompile(`^[-+ ]?\s*---\s*$`)
+)
+
+// maskingWriter masks complete lines, tracking whether they're inside the data of a kubernetes secret
+type maskingWriter struct {
+	masker     *outputMasker
+	out        io.Writer
+	buffer     []byte
+	inSecret   bool
+	dataIndent int
+}
+
+func (w *maskingWriter) Write(p []byte) (int, error) {
+	w.buffer = append(w.buffer, p...)
+	for {
+		i := strings.IndexByte(string(w.buffer), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(w.buffer[:i])
+		w.buffer = w.buffer[i+1:]
+		if _, err := io.WriteString(w.out, w.maskLine(line)+"\n"); err != nil {
+			return len(p), err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *maskingWriter) Close() error {
+	if len(w.buffer) == 0 {
+		return nil
+	}
+	line := string(w.buffer)
+	w.buffer = nil
+	_, err := io.WriteString(w.out, w.maskLine(line))
+	return err
+}
+
+func (w *maskingWriter) maskLine(line string) string {
+	// lines in helm diff output start with + or - for added and removed lines
+	content := line
+	if strings.HasPrefix(content, "+") || strings.HasPrefix(content, "-") {
+		content = content[1:]
+	}
+	trimmed := strings.TrimLeft(content, " ")
+	indent := len(content) - len(trimmed)
+
+	switch {
+	case diffHeaderRegex.MatchString(line):
+		w.inSecret = strings.Contains(line, ", Secret (")
+		w.dataIndent = -1
+	case documentSeparatorRegex.MatchString(line):
+		w.inSecret = false
+		w.dataIndent = -1
+	case w.dataIndent >= 0 && trimmed != "" && indent > w.dataIndent:
+		if i := strings.Index(trimmed, ":"); i > 0 && !strings.HasSuffix(trimmed, ":") {
+			return line[:len(line)-len(trimmed)] + trimmed[:i] + ": ***"
+		}
+		if !strings.HasSuffix(trimmed, ":") {
+			return line[:len(line)-len(trimmed)] + "***"
+		}
+	case w.dataIndent >= 0 && trimmed != "":
+		w.dataIndent = -1
+	}
+
+	if trimmed == "kind: Secret" {
+		w.inSecret = true
+	}
+	if w.inSecret && (trimmed == "data:" || trimmed == "stringData:") {
+		w.dataIndent = indent
+	}
+
+	return w.masker.MaskString(line)
+}
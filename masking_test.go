@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputMasker(t *testing.T) {
+	t.Run("MasksScalarValuesUnderSecretKeysWithoutRememberingThem", func(t *testing.T) {
+
+		masker := newOutputMasker(defaultSecretKeyPatterns...)
+		values := map[string]interface{}{
+			"replicas": 3,
+			"database": map[string]interface{}{"user": "app", "password": "s3cr3t-pw"},
+			"secret":   map[string]interface{}{"cloudflareApiKey": "abcdef", "zone": "example.com"},
+		}
+
+		// act
+		masked := masker.MaskValues(values)
+
+		assert.Equal(t, map[string]interface{}{
+			"replicas": 3,
+			"database": map[string]interface{}{"user": "app", "password": "***"},
+			"secret":   map[string]interface{}{"cloudflareApiKey": "***", "zone": "example.com"},
+		}, masked)
+		assert.Equal(t, "s3cr3t-pw", values["database"].(map[string]interface{})["password"])
+		assert.Equal(t, "connecting with s3cr3t-pw", masker.MaskString("connecting with s3cr3t-pw"))
+	})
+
+	t.Run("MasksBooleansAndNumbersUnderSecretKeys", func(t *testing.T) {
+
+		masker := newOutputMasker(defaultSecretKeyPatterns...)
+		values := map[string]interface{}{
+			"serviceAccount": map[string]interface{}{"automountServiceAccountToken": true},
+			"tokenTTL":       3600,
+			"pin":            map[string]interface{}{"password": 1234},
+		}
+
+		// act
+		masked := masker.MaskValues(values)
+
+		assert.Equal(t, map[string]interface{}{
+			"serviceAccount": map[string]interface{}{"automountServiceAccountToken": "***"},
+			"tokenTTL":       3600,
+			"pin":            map[string]interface{}{"password": "***"},
+		}, masked)
+		assert.Equal(t, "ready: true replicas: 3600", masker.MaskString("ready: true replicas: 3600"))
+	})
+
+	t.Run("DoesNotMaskKeysReferringToSecretsByName", func(t *testing.T) {
+
+		masker := newOutputMasker(defaultSecretKeyPatterns...)
+		values := map[string]interface{}{
+			"existingSecret": "mychart",
+			"tls":            map[string]interface{}{"secretName": "mychart-tls"},
+			"secretsManager": map[string]interface{}{"region": "europe-west1"},
+			"auth":           map[string]interface{}{"passwordSecretRef": "mychart-auth"},
+		}
+
+		// act
+		masked := masker.MaskValues(values)
+
+		assert.Equal(t, values, masked)
+		assert.Equal(t, "deployment.apps/mychart created in region europe-west1", masker.MaskString("deployment.apps/mychart created in region europe-west1"))
+	})
+
+	t.Run("MasksValuesUnderAddedKeyPatterns", func(t *testing.T) {
+
+		masker := newOutputMasker(defaultSecretKeyPatterns...)
+		err := masker.AddKeyPatterns(`^dsn$`)
+
+		// act
+		masked := masker.MaskValues(map[string]interface{}{"dsn": "postgres://app:pw@db/app"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"dsn": "***"}, masked)
+	})
+
+	t.Run("MasksValuesThatAreKnownSecretsUnderAnyKey", func(t *testing.T) {
+
+		masker := newOutputMasker(defaultSecretKeyPatterns...)
+		masker.AddSecrets("-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----")
+
+		// act
+		masked := masker.MaskValues(map[string]interface{}{"tls": map[string]interface{}{"cert": "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"}, "host": "a.example.com"})
+
+		assert.Equal(t, map[string]interface{}{"tls": map[string]interface{}{"cert": "***"}, "host": "a.example.com"}, masked)
+	})
+
+	t.Run("IgnoresVeryShortSecrets", func(t *testing.T) {
+
+		masker := newOutputMasker()
+
+		// act
+		masker.AddSecrets("no", "")
+
+		assert.Equal(t, "no changes", masker.MaskString("no changes"))
+	})
+}
+
+func TestMaskingWriter(t *testing.T) {
+	t.Run("MasksKnownSecretsInEveryLine", func(t *testing.T) {
+
+		masker := newOutputMasker()
+		masker.AddSecrets("t0k3n-value")
+		var out bytes.Buffer
+		w := masker.Writer(&out)
+
+		// act
+		_, _ = w.Write([]byte("Error: login failed with t0k3"))
+		_, _ = w.Write([]byte("n-value\nretrying with t0k3n-value"))
+		_ = w.Close()
+
+		assert.Equal(t, "Error: login failed with ***\nretrying with ***", out.String())
+	})
+
+	t.Run("MasksDataOfSecretsInDiffOutput", func(t *testing.T) {
+
+		masker := newOutputMasker()
+		var out bytes.Buffer
+		w := masker.Writer(&out)
+		diff := `default, myapp, ConfigMap (v1) has changed:
+  data:
+-   logLevel: warn
++   logLevel: debug
+default, myapp, Secret (v1) has been added:
++ apiVersion: v1
++ kind: Secret
++ data:
++   password: czNjcjN0
++   certificate: |
++     -----BEGIN CERTIFICATE-----
++ type: Opaque
+---
+kind: ConfigMap
+data:
+  url: https://example.com
+`
+
+		// act
+		_, _ = w.Write([]byte(diff))
+		_ = w.Close()
+
+		assert.Equal(t, `default, myapp, ConfigMap (v1) has changed:
+  data:
+-   logLevel: warn
++   logLevel: debug
+default, myapp, Secret (v1) has been added:
++ apiVersion: v1
++ kind: Secret
++ data:
++   password: ***
++   certificate: ***
++     ***
++ type: Opaque
+---
+kind: ConfigMap
+data:
+  url: https://example.com
+`, out.String())
+	})
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// registryClient is used to query oci registries; tests replace it to trust their stand-in registry
+var registryClient = &http.Client{Timeout: 60 * time.Second}
+
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// resolveManifestDigest returns the digest of the manifest a tag points to, using the token flow of the registry if it requires authentication
+func resolveManifestDigest(ctx context.Context, repository, tag string, auth *repositoryAuth) (string, error) {
+	parts := strings.SplitN(repository, "/", 2)
+	if len(parts) != 2 {
+		return "", fmt.Errorf("repository %v has no registry host", repository)
+	}
+	manifestURL := fmt.Sprintf("https://%v/v2/%v/manifests/%v", parts[0], parts[1], tag)
+
+	response, err := headManifest(ctx, manifestURL, "")
+	if err != nil {
+		return "", err
+	}
+	if response.StatusCode == http.StatusUnauthorized {
+		token, err := fetchRegistryToken(ctx, response.Header.Get("WWW-Authenticate"), auth)
+		if err != nil {
+			return "", err
+		}
+		response, err = headManifest(ctx, manifestURL, token)
+		if err != nil {
+			return "", err
+		}
+	}
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed retrieving manifest for %v:%v: registry responded with status %v", repository, tag, response.StatusCode)
+	}
+
+	digest := response.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry returned no digest for manifest %v:%v", repository, tag)
+	}
+	return digest, nil
+}
+
+func headManifest(ctx context.Context, manifestURL, token string) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodHead, manifestURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating request for %v: %w", manifestURL, err)
+	}
+	request.Header.Set("Accept", strings.Join(manifestMediaTypes, ", "))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	response, err := registryClient.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed requesting %v: %w", manifestURL, err)
+	}
+	response.Body.Close()
+	return response, nil
+}
+
+// fetchRegistryToken requests a bearer token from the realm in the challenge of the registry, with basic auth if credentials are set
+func fetchRegistryToken(ctx context.Context, challenge string, auth *repositoryAuth) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("registry requires unsupported authentication '%v'", challenge)
+	}
+	challengeParams := parseChallengeParams(strings.TrimPrefix(challenge, "Bearer "))
+	realm := challengeParams["realm"]
+	if realm == "" {
+		return "", fmt.Errorf("registry challenge '%v' has no realm", challenge)
+	}
+
+	query := url.Values{}
+	for _, key := range []string{"service", "scope"} {
+		if challengeParams[key] != "" {
+			query.Set(key, challengeParams[key])
+		}
+	}
+	tokenURL := realm
+	if len(query) > 0 {
+		tokenURL += "?" + query.Encode()
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, tokenURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed creating request for %v: %w", tokenURL, err)
+	}
+	if auth != nil {
+		request.SetBasicAuth(auth.Username, auth.Password)
+	}
+
+	response, err := registryClient.Do(request)
+	if err != nil {
+		return "", fmt.Errorf("failed requesting registry token from %v: %w", realm, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed requesting registry token from %v: responded with status %v", realm, response.StatusCode)
+	}
+
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	err = json.NewDecoder(response.Body).Decode(&body)
+	if err != nil {
+		return "", fmt.Errorf("failed decoding registry token response from %v: %w", realm, err)
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+// parseChallengeParams parses the comma separated key="value" pairs of a WWW-Authenticate header
+func parseChallengeParams(s string) map[string]string {
+	challengeParams := map[string]string{}
+	for s != "" {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := strings.Index(s[1:], `"`)
+			if end < 0 {
+				value, s = s[1:], ""
+			} else {
+				value, s = s[1:end+1], s[end+2:]
+			}
+		} else if comma := strings.Index(s, ","); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+		challengeParams[key] = value
+	}
+	return challengeParams
+}
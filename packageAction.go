@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+var repositoryNameRegex = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+
+type packageAction struct{}
+
+func (packageAction) Name() string {
+	return "package"
+}
+
+func (packageAction) Description() string {
+	return "Packages the chart into <chart>-<version>.tgz after updating its dependencies"
+}
+
+func (packageAction) RequiredParams() []string {
+	return []string{"chart", "helmSubdir", "appVersion", "version"}
+}
+
+func (packageAction) OptionalParams() []string {
+	return []string{"dependencyCredentials", "signingCredentials"}
+}
+
+func (packageAction) Run(ctx context.Context, runner CommandRunner, params params) error {
+	err := addRequirementRepositories(ctx, runner, params)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Packaging chart %v with app version %v and version %v...", params.Chart, params.AppVersion, params.Version)
+	chartDirectory := filepath.Join(params.HelmSubdirectory, params.Chart)
+	packageArgs := newHelmArgs("package").
+		Flag("--app-version", params.AppVersion).
+		Flag("--version", params.Version).
+		BoolFlag("--dependency-update", true)
+
+	if params.SigningCredentials == "" {
+		return runner.RunCommandWithArgs(ctx, "helm", packageArgs.Arg(chartDirectory).Args())
+	}
+
+	key, err := initSigningKey(params)
+	if err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Signing chart %v with key %v...", params.Chart, key.KeyName)
+	packageArgs.
+		BoolFlag("--sign", true).
+		Flag("--key", key.KeyName).
+		Flag("--keyring", key.KeyringPath)
+	if key.Passphrase == "" {
+		return runner.RunCommandWithArgs(ctx, "helm", packageArgs.Arg(chartDirectory).Args())
+	}
+	return runner.RunCommandWithArgsAndStdin(ctx, "helm", packageArgs.Flag("--passphrase-file", "-").Arg(chartDirectory).Args(), key.Passphrase)
+}
+
+// addRequirementRepositories adds the repositories of the chart dependencies and logs in to their oci registries, so helm package --dependency-update can fetch them
+func addRequirementRepositories(ctx context.Context, runner CommandRunner, params params) error {
+	dependencies, err := readChartDependencies(filepath.Join(params.HelmSubdirectory, params.Chart))
+	if err != nil {
+		return err
+	}
+
+	for _, repository := range dependencyRepositories(dependencies) {
+		auth, err := resolveRepositoryAuth(ctx, repository.URL, params.DependencyCredentials)
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msgf("Adding required repository %v as %v...", repository.URL, repository.Name)
+		repoAddArgs := []string{"repo", "add", repository.Name, repository.URL}
+		if auth == nil {
+			err = runner.RunCommandWithArgs(ctx, "helm", repoAddArgs)
+		} else {
+			err = runner.RunCommandWithArgsAndStdin(ctx, "helm", append(repoAddArgs, "--username", auth.Username, "--password-stdin"), auth.Password)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, registry := range dependencyRegistries(dependencies) {
+		auth, err := resolveRepositoryAuth(ctx, "oci://"+registry, params.DependencyCredentials)
+		if err != nil {
+			return err
+		}
+		if auth == nil {
+			log.Info().Msgf("No credential mapped to registry %v, pulling anonymously...", registry)
+			continue
+		}
+
+		log.Info().Msgf("Logging in to required registry %v...", registry)
+		err = runner.RunCommandWithArgsAndStdin(ctx, "helm", []string{"registry", "login", registry, "--username", auth.Username, "--password-stdin"}, auth.Password)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readChartDependencies returns the dependencies from both the Chart.yaml file and the Helm 2 style requirements.yaml file in the chart directory
+func readChartDependencies(chartDirectory string) (dependencies []dependency, err error) {
+	chartPath := filepath.Join(chartDirectory, "Chart.yaml")
+	if foundation.FileExists(chartPath) {
+		data, err := ioutil.ReadFile(chartPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading chart file at %v: %w", chartPath, err)
+		}
+
+		var metadata chartMetadata
+		if err := yaml.Unmarshal(data, &metadata); err != nil {
+			return nil, fmt.Errorf("failed unmarshalling chart file at %v: %w", chartPath, err)
+		}
+		dependencies = append(dependencies, metadata.Dependencies...)
+	}
+
+	requirementsPath := filepath.Join(chartDirectory, "requirements.yaml")
+	if foundation.FileExists(requirementsPath) {
+		data, err := ioutil.ReadFile(requirementsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading requirements file at %v: %w", requirementsPath, err)
+		}
+
+		var requirements requirements
+		if err := yaml.Unmarshal(data, &requirements); err != nil {
+			return nil, fmt.Errorf("failed unmarshalling requirements file at %v: %w", requirementsPath, err)
+		}
+		dependencies = append(dependencies, requirements.Dependencies...)
+	}
+
+	return dependencies, nil
+}
+
+// dependencyRepository is a chart repository to add before updating dependencies
+type dependencyRepository struct {
+	Name string
+	URL  string
+}
+
+// dependencyRepositories returns each remote http(s) repository used by the dependencies once, named after its url; local file:// charts and @name or alias:name references to already added repositories are skipped
+func dependencyRepositories(dependencies []dependency) (repositories []dependencyRepository) {
+	seen := map[string]bool{}
+	for _, d := range dependencies {
+		url := strings.TrimSuffix(strings.TrimSpace(d.Repository), "/")
+		if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+			if url != "" && !strings.HasPrefix(url, "oci://") {
+				log.Info().Msgf("Skipping repository %v for dependency %v, it's not a remote http(s) repository", d.Repository, d.Name)
+			}
+			continue
+		}
+		if seen[url] {
+			continue
+		}
+		seen[url] = true
+		repositories = append(repositories, dependencyRepository{Name: repositoryName(url), URL: url})
+	}
+	return
+}
+
+// dependencyRegistries returns the host of each oci:// registry used by the dependencies once
+func dependencyRegistries(dependencies []dependency) (registries []string) {
+	for _, d := range dependencies {
+		url := strings.TrimSpace(d.Repository)
+		if !strings.HasPrefix(url, "oci://") {
+			continue
+		}
+		registry := strings.Split(strings.TrimPrefix(url, "oci://"), "/")[0]
+		if registry != "" && !foundation.StringArrayContains(registries, registry) {
+			registries = append(registries, registry)
+		}
+	}
+	return
+}
+
+// repositoryName derives a stable helm repository name from its url, for example charts.bitnami.com/bitnami becomes charts-bitnami-com-bitnami
+func repositoryName(url string) string {
+	name := strings.TrimPrefix(strings.TrimPrefix(url, "https://"), "http://")
+	name = strings.ToLower(repositoryNameRegex.ReplaceAllString(name, "-"))
+	return strings.Trim(name, "-")
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPackageAction(t *testing.T) {
+	t.Run("AddsRepositoriesFromChartYamlAndRequirementsYamlOnce", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = os.MkdirAll(filepath.Join("helm", "mychart"), 0755)
+		_ = ioutil.WriteFile(filepath.Join("helm", "mychart", "Chart.yaml"), []byte(`apiVersion: v2
+name: mychart
+version: 0.1.0
+dependencies:
+- name: postgresql
+  version: 10.x.x
+  repository: https://charts.bitnami.com/bitnami
+- name: redis
+  version: 12.x.x
+  repository: https://charts.bitnami.com/bitnami/
+- name: common
+  version: 0.1.0
+  repository: file://../common
+- name: memcached
+  version: 5.x.x
+  repository: "@stable"
+- name: nginx
+  version: 1.x.x
+  repository: alias:stable
+`), 0644)
+		_ = ioutil.WriteFile(filepath.Join("helm", "mychart", "requirements.yaml"), []byte(`dependencies:
+- name: estafette-ci
+  version: 1.0.0
+  repository: https://helm.estafette.io
+- name: mysql
+  version: 1.0.0
+  repository: https://charts.bitnami.com/bitnami
+`), 0644)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:            "mychart",
+			HelmSubdirectory: "helm",
+			AppVersion:       "1.0.0",
+			Version:          "1.0.0",
+		}
+
+		// act
+		err := packageAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"helm repo add charts-bitnami-com-bitnami https://charts.bitnami.com/bitnami",
+			"helm repo add helm-estafette-io https://helm.estafette.io",
+			"helm package --app-version 1.0.0 --version 1.0.0 --dependency-update helm/mychart",
+		}, runner.commandLines())
+	})
+
+	t.Run("PackagesChartWithoutDependencies", func(t *testing.T) {
+
+		inTempDir(t)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:            "mychart",
+			HelmSubdirectory: "helm",
+			AppVersion:       "1.0.0",
+			Version:          "1.0.0",
+		}
+
+		// act
+		err := packageAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"helm package --app-version 1.0.0 --version 1.0.0 --dependency-update helm/mychart",
+		}, runner.commandLines())
+	})
+
+	t.Run("PassesMappedCredentialsOnStdinAndLogsInToOciRegistries", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		withHelmRepositoryCredentials(t)
+		withSecretMasker(t)
+		_ = os.MkdirAll(filepath.Join("helm", "mychart"), 0755)
+		_ = ioutil.WriteFile(filepath.Join("helm", "mychart", "Chart.yaml"), []byte(`apiVersion: v2
+name: mychart
+version: 0.1.0
+dependencies:
+- name: private
+  version: 1.0.0
+  repository: https://charts.example.com/private
+- name: internal
+  version: 1.0.0
+  repository: https://charts.example.com/internal
+- name: public
+  version: 1.0.0
+  repository: https://helm.estafette.io
+- name: gar
+  version: 1.0.0
+  repository: oci://europe-docker.pkg.dev/my-project/charts
+- name: ghcr
+  version: 1.0.0
+  repository: oci://ghcr.io/estafette/charts
+`), 0644)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:            "mychart",
+			HelmSubdirectory: "helm",
+			AppVersion:       "1.0.0",
+			Version:          "1.0.0",
+			DependencyCredentials: []dependencyCredential{
+				{Repository: "https://charts.example.com", Credentials: "token-auth"},
+				{Repository: "https://charts.example.com/private/", Credentials: "basic-auth"},
+				{Repository: "oci://europe-docker.pkg.dev", Credentials: "gke-production"},
+			},
+		}
+
+		// act
+		err := packageAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"helm repo add charts-example-com-private https://charts.example.com/private --username user --password-stdin",
+			"helm repo add charts-example-com-internal https://charts.example.com/internal --username token --password-stdin",
+			"helm repo add helm-estafette-io https://helm.estafette.io",
+			"helm registry login europe-docker.pkg.dev --username _json_key --password-stdin",
+			"helm package --app-version 1.0.0 --version 1.0.0 --dependency-update helm/mychart",
+		}, runner.commandLines())
+		assert.Equal(t, []string{"s3cr3t", "t0k3n", "", `{"client_email":"sa@my-project.iam.gserviceaccount.com"}`, ""}, runner.inputs)
+		assert.Equal(t, "password ***", secretMasker.MaskString("password s3cr3t"))
+	})
+
+	t.Run("DoesNotPassCredentialsToRepositoriesOnOtherHostsWithSamePrefix", func(t *testing.T) {
+
+		inTempDir(t)
+		withHelmRepositoryCredentials(t)
+		_ = os.MkdirAll(filepath.Join("helm", "mychart"), 0755)
+		_ = ioutil.WriteFile(filepath.Join("helm", "mychart", "Chart.yaml"), []byte(`apiVersion: v2
+name: mychart
+version: 0.1.0
+dependencies:
+- name: lookalike
+  version: 1.0.0
+  repository: https://charts.example.com.evil.io/charts
+`), 0644)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:                 "mychart",
+			HelmSubdirectory:      "helm",
+			AppVersion:            "1.0.0",
+			Version:               "1.0.0",
+			DependencyCredentials: []dependencyCredential{{Repository: "https://charts.example.com", Credentials: "basic-auth"}},
+		}
+
+		// act
+		err := packageAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "helm repo add charts-example-com-evil-io-charts https://charts.example.com.evil.io/charts", runner.commandLines()[0])
+		assert.Equal(t, "", runner.inputs[0])
+	})
+
+	t.Run("ReturnsErrorIfMappedCredentialDoesNotExist", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		withHelmRepositoryCredentials(t)
+		_ = os.MkdirAll(filepath.Join("helm", "mychart"), 0755)
+		_ = ioutil.WriteFile(filepath.Join("helm", "mychart", "Chart.yaml"), []byte(`apiVersion: v2
+name: mychart
+version: 0.1.0
+dependencies:
+- name: private
+  version: 1.0.0
+  repository: https://charts.example.com
+`), 0644)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:                 "mychart",
+			HelmSubdirectory:      "helm",
+			AppVersion:            "1.0.0",
+			Version:               "1.0.0",
+			DependencyCredentials: []dependencyCredential{{Repository: "https://charts.example.com", Credentials: "unknown"}},
+		}
+
+		// act
+		err := packageAction{}.Run(context.Background(), runner, params)
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, len(runner.commands))
+	})
+
+	t.Run("SignsChartWithKeyringAndPassphraseOnStdin", func(t *testing.T) {
+
+		inTempDir(t)
+		withSigningCredentials(t)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:              "mychart",
+			HelmSubdirectory:   "helm",
+			AppVersion:         "1.0.0",
+			Version:            "1.0.0",
+			SigningCredentials: "chart-signing",
+		}
+
+		// act
+		err := packageAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"helm package --app-version 1.0.0 --version 1.0.0 --dependency-update --sign --key Estafette Bot --keyring " + signingKeyringPath + " --passphrase-file - helm/mychart",
+		}, runner.commandLines())
+		assert.Equal(t, []string{"p4ss"}, runner.inputs)
+		keyring, _ := ioutil.ReadFile(signingKeyringPath)
+		assert.Equal(t, "secret", string(keyring))
+		publicKeyring, _ := ioutil.ReadFile(verificationKeyringPath)
+		assert.Equal(t, "public", string(publicKeyring))
+	})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	stepStatusSucceeded = "succeeded"
+	stepStatusFailed    = "failed"
+	stepStatusSkipped   = "skipped"
+)
+
+// stepResult contains the outcome of a single action in a pipeline
+type stepResult struct {
+	Name     string
+	Status   string
+	Duration time.Duration
+	Err      error
+}
+
+// runPipeline runs the actions in order with the same parameters and stops at the first failing action; it returns a result for every action, including the skipped ones
+func runPipeline(ctx context.Context, runner CommandRunner, registry *actionRegistry, names []string, params params) (results []stepResult, err error) {
+
+	for _, name := range names {
+		if err != nil {
+			results = append(results, stepResult{Name: name, Status: stepStatusSkipped})
+			continue
+		}
+
+		a, ok := registry.Get(name)
+		if !ok {
+			err = registry.UnsupportedError(name)
+			results = append(results, stepResult{Name: name, Status: stepStatusFailed, Err: err})
+			continue
+		}
+
+		if len(names) > 1 {
+			log.Info().Msgf("Running action %v...", name)
+		}
+
+		start := time.Now()
+		stepErr := a.Run(ctx, runner, params)
+		result := stepResult{Name: name, Status: stepStatusSucceeded, Duration: time.Since(start), Err: stepErr}
+		if stepErr != nil {
+			result.Status = stepStatusFailed
+			err = fmt.Errorf("action %v failed: %w", name, stepErr)
+		}
+		results = append(results, result)
+	}
+
+	return
+}
+
+// pipelineSummary returns a table with the status and duration of every action in the pipeline
+func pipelineSummary(results []stepResult) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("%-12v %-10v %v\n", "ACTION", "STATUS", "DURATION"))
+	for _, r := range results {
+		duration := "-"
+		if r.Status != stepStatusSkipped {
+			duration = r.Duration.Round(time.Millisecond).String()
+		}
+		sb.WriteString(fmt.Sprintf("%-12v %-10v %v\n", r.Name, r.Status, duration))
+	}
+	return sb.String()
+}
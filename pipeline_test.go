@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunPipeline(t *testing.T) {
+	t.Run("RunsAllActionsInOrder", func(t *testing.T) {
+
+		runs := []string{}
+		registry := newActionRegistry(fakeAction{name: "lint", runs: &runs}, fakeAction{name: "package", runs: &runs}, fakeAction{name: "test", runs: &runs})
+
+		// act
+		results, err := runPipeline(context.Background(), &fakeCommandRunner{}, registry, []string{"test", "lint", "package"}, params{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"test", "lint", "package"}, runs)
+		if assert.Equal(t, 3, len(results)) {
+			assert.Equal(t, stepStatusSucceeded, results[0].Status)
+			assert.Equal(t, stepStatusSucceeded, results[1].Status)
+			assert.Equal(t, stepStatusSucceeded, results[2].Status)
+		}
+	})
+
+	t.Run("StopsAtFirstFailingActionAndSkipsTheRest", func(t *testing.T) {
+
+		runs := []string{}
+		registry := newActionRegistry(fakeAction{name: "lint", runs: &runs}, fakeAction{name: "package", runs: &runs, err: errors.New("no chart")}, fakeAction{name: "test", runs: &runs})
+
+		// act
+		results, err := runPipeline(context.Background(), &fakeCommandRunner{}, registry, []string{"lint", "package", "test"}, params{})
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "action package failed: no chart", err.Error())
+		}
+		assert.Equal(t, []string{"lint", "package"}, runs)
+		if assert.Equal(t, 3, len(results)) {
+			assert.Equal(t, stepStatusSucceeded, results[0].Status)
+			assert.Equal(t, stepStatusFailed, results[1].Status)
+			assert.Equal(t, stepStatusSkipped, results[2].Status)
+		}
+	})
+
+	t.Run("FailsForUnknownAction", func(t *testing.T) {
+
+		runs := []string{}
+		registry := newActionRegistry(fakeAction{name: "lint", runs: &runs})
+
+		// act
+		results, err := runPipeline(context.Background(), &fakeCommandRunner{}, registry, []string{"deploy", "lint"}, params{})
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, len(runs))
+		if assert.Equal(t, 2, len(results)) {
+			assert.Equal(t, stepStatusFailed, results[0].Status)
+			assert.Equal(t, stepStatusSkipped, results[1].Status)
+		}
+	})
+}
+
+func TestPipelineSummary(t *testing.T) {
+	t.Run("ListsStatusOfEveryAction", func(t *testing.T) {
+
+		results := []stepResult{
+			{Name: "lint", Status: stepStatusSucceeded},
+			{Name: "package", Status: stepStatusFailed},
+			{Name: "test", Status: stepStatusSkipped},
+		}
+
+		// act
+		summary := pipelineSummary(results)
+
+		assert.Equal(t, "ACTION       STATUS     DURATION\nlint         succeeded  0s\npackage      failed     0s\ntest         skipped    -\n", summary)
+	})
+}
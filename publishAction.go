@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+type publishAction struct{}
+
+func (publishAction) Name() string {
+	return "publish"
+}
+
+func (publishAction) Description() string {
+	return "Publishes the packaged chart to a cloned git repository, a gcs bucket, an oci registry or chartmuseum"
+}
+
+func (publishAction) RequiredParams() []string {
+	return []string{"chart", "version"}
+}
+
+func (publishAction) OptionalParams() []string {
+	return []string{"allowOverwrite", "appVersion", "bucket", "chartMuseumUrl", "chartMuseumCredentials", "chartMuseumOnConflict", "cosign", "cosignKey", "credentials", "registry", "registryCredentials", "repoDir", "repoChartsSubdir", "repoUrl", "repoBranch", "repoPushAttempts"}
+}
+
+func (publishAction) Run(ctx context.Context, runner CommandRunner, params params) error {
+	log.Info().Msgf("Publishing chart %v with app version %v and version %v...", params.Chart, params.AppVersion, params.Version)
+
+	targets := params.PublishTargets()
+	if len(targets) > 1 {
+		return fmt.Errorf("parameters %v are set, but a chart can only be published to one of them at a time; please use a separate stage for each", strings.Join(targets, " and "))
+	}
+
+	filename := fmt.Sprintf("%v-%v.tgz", params.Chart, params.Version)
+	var err error
+	switch {
+	case params.Bucket != "":
+		err = publishToBucket(ctx, runner, params, filename)
+	case params.Registry != "":
+		err = publishToRegistry(ctx, runner, params, filename)
+	case params.ChartMuseumURL != "":
+		err = publishToChartMuseum(ctx, params, filename)
+	default:
+		err = publishToGitRepository(ctx, runner, params, filename)
+	}
+	if errors.Is(err, errChartAlreadyPublished) {
+		return nil
+	}
+	return err
+}
+
+// errChartAlreadyPublished is returned when the exact same package is already published, which makes publishing it again a no-op
+var errChartAlreadyPublished = errors.New("chart is already published with the same digest")
+
+// verifyPublishedDigest compares the digest of the package with the digest of the same version in the target; it returns errChartAlreadyPublished if they're equal and an error if they differ, unless allowOverwrite is set
+func verifyPublishedDigest(params params, target, publishedDigest, filename string) error {
+	if publishedDigest == "" {
+		log.Info().Msgf("Chart %v version %v is not published to %v yet", params.Chart, params.Version, target)
+		return nil
+	}
+	if params.DryRun {
+		// the package doesn't exist in a dry run, since packaging is skipped as well
+		log.Info().Msgf("Dry run: skipping comparing digest with version %v published to %v with digest %v...", params.Version, target, publishedDigest)
+		return nil
+	}
+
+	digest, err := fileDigest(filename)
+	if err != nil {
+		return err
+	}
+	if digest == publishedDigest {
+		log.Info().Msgf("Chart %v version %v is already published to %v with the same digest %v, nothing to do", params.Chart, params.Version, target, digest)
+		return errChartAlreadyPublished
+	}
+	if params.AllowOverwrite {
+		log.Warn().Msgf("Chart %v version %v is already published to %v with digest %v, overwriting it with digest %v because allowOverwrite is set", params.Chart, params.Version, target, publishedDigest, digest)
+		return nil
+	}
+	return fmt.Errorf("chart %v version %v is already published to %v with digest %v, while the package has digest %v; publish a new version instead or set allowOverwrite: true to replace it", params.Chart, params.Version, target, publishedDigest, digest)
+}
+
+// indexDigest returns the digest of a chart version in an index, or empty if the index doesn't contain it
+func indexDigest(index *repositoryIndex, chart, version string) string {
+	if entry := index.Get(chart, version); entry != nil {
+		if digest, ok := entry["digest"].(string); ok {
+			return digest
+		}
+	}
+	return ""
+}
+
+// publishToBucket pushes the chart to a gcs bucket with the helm gcs plugin
+func publishToBucket(ctx context.Context, runner CommandRunner, params params, filename string) error {
+	err := initBucketRepository(ctx, runner, params)
+	if err != nil {
+		return err
+	}
+
+	if params.DryRun {
+		log.Info().Msgf("Dry run: skipping comparing digest with the version in bucket %v...", params.Bucket)
+	} else {
+		index, err := loadBucketIndex(ctx, runner, params)
+		if err != nil {
+			return fmt.Errorf("failed retrieving index file from bucket %v to check whether the version is published already: %w", params.Bucket, err)
+		}
+		err = verifyPublishedDigest(params, "bucket "+params.Bucket, indexDigest(index, params.Chart, params.Version), filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	gcsPushArgs := newHelmArgs("gcs", "push", filename, "gcs-repo").
+		BoolFlag("--force", params.AllowOverwrite).
+		BoolFlag("--retry", true)
+	err = runner.RunCommandWithArgs(ctx, "helm", gcsPushArgs.Args())
+	if err != nil {
+		return err
+	}
+
+	// helm gcs push doesn't upload the provenance file, so copy it next to the chart where helm fetch --verify looks for it
+	if foundation.FileExists(filename + ".prov") {
+		log.Info().Msgf("Uploading provenance file %v.prov...", filename)
+		return runner.RunCommandWithArgs(ctx, "gcloud", []string{"storage", "cp", filename + ".prov", "gs://" + params.Bucket + "/" + filename + ".prov"})
+	}
+	return nil
+}
+
+// initBucketRepository authenticates the helm gcs plugin with the gcp credential and adds the bucket as repository gcs-repo
+func initBucketRepository(ctx context.Context, runner CommandRunner, params params) error {
+	credential, err := initCredential(ctx, runner, params)
+	if err != nil {
+		return err
+	}
+
+	if credential.AdditionalProperties.ServiceAccountKeyfile != "" {
+		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", serviceAccountKeyfilePath)
+	}
+	return runner.RunCommandWithArgs(ctx, "helm", []string{"repo", "add", "gcs-repo", "gs://" + params.Bucket})
+}
+
+// loadBucketIndex downloads the index file of the bucket to a temporary directory and loads it
+func loadBucketIndex(ctx context.Context, runner CommandRunner, params params) (*repositoryIndex, error) {
+	tempDirectory, err := ioutil.TempDir("", "gcs-repo")
+	if err != nil {
+		return nil, fmt.Errorf("failed creating temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDirectory)
+
+	indexPath := filepath.Join(tempDirectory, "index.yaml")
+	err = runner.RunCommandWithArgs(ctx, "gcloud", []string{"storage", "cp", "gs://" + params.Bucket + "/index.yaml", indexPath})
+	if err != nil {
+		return nil, err
+	}
+	return loadRepositoryIndex(indexPath)
+}
+
+// notFoundRegex matches the error of helm pull for a tag that doesn't exist in the registry
+var notFoundRegex = regexp.MustCompile(`(?i)(not found|manifest unknown)`)
+
+// publishToRegistry pushes the chart to an oci registry, after logging in with the credential set by registryCredentials
+func publishToRegistry(ctx context.Context, runner CommandRunner, params params, filename string) error {
+	registry := strings.Split(strings.TrimPrefix(params.Registry, "oci://"), "/")[0]
+
+	var auth *repositoryAuth
+	if params.RegistryCredentials != "" {
+		var err error
+		auth, err = resolveRepositoryAuth(ctx, params.Registry, []dependencyCredential{{Repository: params.Registry, Credentials: params.RegistryCredentials}})
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msgf("Logging in to registry %v...", registry)
+		err = runner.RunCommandWithArgsAndStdin(ctx, "helm", []string{"registry", "login", registry, "--username", auth.Username, "--password-stdin"}, auth.Password)
+		if err != nil {
+			return err
+		}
+	}
+
+	chartReference := strings.TrimSuffix(params.Registry, "/") + "/" + params.Chart
+	if params.DryRun {
+		log.Info().Msgf("Dry run: skipping comparing digest with tag %v of %v...", params.Version, chartReference)
+	} else {
+		tempDirectory, err := ioutil.TempDir("", "oci-repo")
+		if err != nil {
+			return fmt.Errorf("failed creating temporary directory: %w", err)
+		}
+		defer os.RemoveAll(tempDirectory)
+
+		// the pull uses the registry login and credential helpers of helm like the push does; only a tag the registry doesn't have is unpublished, since any other failure, like rejected credentials, could otherwise overwrite a published chart
+		publishedDigest := ""
+		err = runner.RunCommandWithArgs(ctx, "helm", []string{"pull", chartReference, "--version", params.Version, "--destination", tempDirectory})
+		switch {
+		case err != nil && notFoundRegex.MatchString(err.Error()):
+		case err != nil:
+			return fmt.Errorf("failed checking whether chart %v version %v is published to %v: %w", params.Chart, params.Version, params.Registry, err)
+		default:
+			publishedDigest, err = fileDigest(filepath.Join(tempDirectory, filename))
+			if err != nil {
+				return err
+			}
+		}
+		err = verifyPublishedDigest(params, chartReference, publishedDigest, filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info().Msgf("Pushing chart %v to %v...", filename, params.Registry)
+	err := runner.RunCommandWithArgs(ctx, "helm", []string{"push", filename, strings.TrimSuffix(params.Registry, "/")})
+	if err != nil {
+		return err
+	}
+
+	if params.Cosign {
+		return signPublishedChart(ctx, runner, params, filename, auth)
+	}
+	return nil
+}
+
+// publishToGitRepository copies the chart into the cloned git repository, updates its index and pushes the changes
+func publishToGitRepository(ctx context.Context, runner CommandRunner, params params, filename string) error {
+	chartsDirectory := filepath.Join(params.RepositoryDirectory, params.RepositoryChartsSubdirectory)
+
+	return pushRepositoryChanges(ctx, runner, params, fmt.Sprintf("%v v%v", params.Chart, params.Version), func() error {
+		// check again for every attempt, since the version can be published by another pipeline in the meantime
+		index, err := loadRepositoryIndex(filepath.Join(params.RepositoryDirectory, "index.yaml"))
+		if err != nil {
+			return err
+		}
+		err = verifyPublishedDigest(params, "repository "+params.RepositoryURL, indexDigest(index, params.Chart, params.Version), filename)
+		if err != nil {
+			return err
+		}
+
+		err = runner.RunCommandWithArgs(ctx, "mkdir", []string{"-p", chartsDirectory})
+		if err != nil {
+			return err
+		}
+		err = runner.RunCommandWithArgs(ctx, "cp", []string{filename, chartsDirectory})
+		if err != nil {
+			return err
+		}
+		if foundation.FileExists(filename + ".prov") {
+			log.Info().Msgf("Copying provenance file %v.prov...", filename)
+			err = runner.RunCommandWithArgs(ctx, "cp", []string{filename + ".prov", chartsDirectory})
+			if err != nil {
+				return err
+			}
+		}
+
+		url := chartURL(params.RepositoryURL, filepath.Join(params.RepositoryChartsSubdirectory, filepath.Base(filename)))
+		if params.DryRun {
+			// adding a chart to the index reads the package for its metadata and digest, which doesn't exist in a dry run
+			log.Info().Msgf("Dry run: skipping adding chart %v version %v with url %v to index file for repository %v...", params.Chart, params.Version, url, params.RepositoryURL)
+			return nil
+		}
+
+		log.Info().Msgf("Adding chart %v version %v to index file for repository %v...", params.Chart, params.Version, params.RepositoryURL)
+		return updateRepositoryIndex(params, func(index *repositoryIndex) error {
+			return index.Add(filename, url)
+		})
+	})
+}
+
+// updateRepositoryIndex loads the index.yaml file of the cloned repository, applies the update and saves it, so only the affected chart versions change
+func updateRepositoryIndex(params params, update func(index *repositoryIndex) error) error {
+	indexPath := filepath.Join(params.RepositoryDirectory, "index.yaml")
+	index, err := loadRepositoryIndex(indexPath)
+	if err != nil {
+		return err
+	}
+	err = update(index)
+	if err != nil {
+		return err
+	}
+	if params.DryRun {
+		log.Info().Msgf("Dry run: skipping writing index file %v...", indexPath)
+		return nil
+	}
+	return index.Save(indexPath)
+}
+
+// errPushRejected is returned when pushing to the repository branch failed because it moved since cloning
+var errPushRejected = errors.New("push rejected because the branch moved")
+
+// pushRetryDelayMillisecond is the delay before the first retry of a rejected push, doubling for every next retry
+var pushRetryDelayMillisecond = 1000
+
+// pushRepositoryChanges applies the changes to the cloned repository, commits and pushes them to the repository branch; if the push is rejected because another pipeline pushed to the branch in the meantime, it rebases by resetting to the remote branch and applying the changes again, with backoff
+func pushRepositoryChanges(ctx context.Context, runner CommandRunner, params params, commitMessage string, apply func() error) error {
+	dir := params.RepositoryDirectory
+	err := runner.RunCommandInDirectoryWithArgs(ctx, dir, "git", []string{"config", "--global", "user.email", "bot@estafette.io"})
+	if err != nil {
+		return err
+	}
+	err = runner.RunCommandInDirectoryWithArgs(ctx, dir, "git", []string{"config", "--global", "user.name", "estafette-bot"})
+	if err != nil {
+		return err
+	}
+
+	attempts := params.RepositoryPushAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	attempt := 0
+	return foundation.Retry(func() error {
+		attempt++
+		if attempt > 1 {
+			log.Warn().Msgf("Branch %v moved, applying changes on top of origin/%v again (attempt %v of %v)...", params.RepositoryBranch, params.RepositoryBranch, attempt, attempts)
+			err := runner.RunCommandInDirectoryWithArgs(ctx, dir, "git", []string{"reset", "--hard", "origin/" + params.RepositoryBranch})
+			if err != nil {
+				return err
+			}
+		}
+
+		err := apply()
+		if err != nil {
+			return err
+		}
+
+		log.Info().Msg("Pushing changes to repository...")
+		err = runner.RunCommandInDirectoryWithArgs(ctx, dir, "git", []string{"status"})
+		if err != nil {
+			return err
+		}
+		err = runner.RunCommandInDirectoryWithArgs(ctx, dir, "git", []string{"add", "--all"})
+		if err != nil {
+			return err
+		}
+		err = runner.RunCommandInDirectoryWithArgs(ctx, dir, "git", []string{"commit", "--allow-empty", "-m", commitMessage})
+		if err != nil {
+			return err
+		}
+		err = runner.RunCommandInDirectoryWithArgs(ctx, dir, "git", []string{"push", "origin", params.RepositoryBranch})
+		if err != nil {
+			if remoteBranchMoved(ctx, runner, params) {
+				return fmt.Errorf("%w: %v", errPushRejected, err)
+			}
+			return err
+		}
+		return nil
+	},
+		foundation.Attempts(uint(attempts)),
+		foundation.DelayMillisecond(pushRetryDelayMillisecond),
+		foundation.ExponentialJitterBackoff(),
+		foundation.LastErrorOnly(true),
+		func(c *foundation.RetryConfig) {
+			c.IsRetryableError = func(err error) bool {
+				return errors.Is(err, errPushRejected)
+			}
+		})
+}
+
+// remoteBranchMoved fetches the repository branch and returns true if it has commits that aren't in the local branch, which makes a push fail as non-fast-forward
+func remoteBranchMoved(ctx context.Context, runner CommandRunner, params params) bool {
+	dir := params.RepositoryDirectory
+	err := runner.RunCommandInDirectoryWithArgs(ctx, dir, "git", []string{"fetch", "origin", params.RepositoryBranch})
+	if err != nil {
+		return false
+	}
+	err = runner.RunCommandInDirectoryWithArgs(ctx, dir, "git", []string{"merge-base", "--is-ancestor", "origin/" + params.RepositoryBranch, "HEAD"})
+	return err != nil
+}
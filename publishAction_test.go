@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishAction(t *testing.T) {
+	t.Run("PushesToGcsBucketIfBucketIsSet", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		writePackagedChart(t, "mychart-0.9.0.tgz", "mychart", "0.9.0")
+		runner := &fakeCommandRunner{
+			effects: map[string]func([]string){
+				"gcloud storage cp": writeIndex(t, "mychart-0.9.0.tgz", "mychart", "0.9.0"),
+			},
+		}
+		params := params{
+			Chart:       "mychart",
+			Version:     "1.0.0",
+			Bucket:      "my-bucket",
+			Credentials: "gke-production",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		commandLines := runner.commandLines()
+		if assert.Equal(t, 5, len(commandLines)) {
+			assert.Equal(t, "gcloud auth activate-service-account sa@my-project.iam.gserviceaccount.com --key-file "+serviceAccountKeyfilePath, commandLines[0])
+			assert.Equal(t, "gcloud config set account sa@my-project.iam.gserviceaccount.com", commandLines[1])
+			assert.Equal(t, "helm repo add gcs-repo gs://my-bucket", commandLines[2])
+			assert.True(t, strings.HasPrefix(commandLines[3], "gcloud storage cp gs://my-bucket/index.yaml "))
+			assert.Equal(t, "helm gcs push mychart-1.0.0.tgz gcs-repo --retry", commandLines[4])
+		}
+		assert.Equal(t, serviceAccountKeyfilePath, os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	})
+
+	t.Run("UploadsProvenanceFileToGcsBucketIfPresent", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		writePackagedChart(t, "mychart-0.9.0.tgz", "mychart", "0.9.0")
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz.prov", []byte("signature"), 0644)
+		runner := &fakeCommandRunner{
+			effects: map[string]func([]string){
+				"gcloud storage cp gs://": writeIndex(t, "mychart-0.9.0.tgz", "mychart", "0.9.0"),
+			},
+		}
+		params := params{
+			Chart:       "mychart",
+			Version:     "1.0.0",
+			Bucket:      "my-bucket",
+			Credentials: "gke-production",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "gcloud storage cp mychart-1.0.0.tgz.prov gs://my-bucket/mychart-1.0.0.tgz.prov", runner.commandLines()[len(runner.commands)-1])
+	})
+
+	t.Run("ForcesPushToGcsBucketIfDigestDiffersAndAllowOverwriteIsSet", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		_ = os.Mkdir("published", 0755)
+		writePackagedChart(t, filepath.Join("published", "mychart-1.0.0.tgz"), "mychart", "1.0.0-changed")
+		runner := &fakeCommandRunner{
+			effects: map[string]func([]string){
+				"gcloud storage cp": writeIndex(t, filepath.Join("published", "mychart-1.0.0.tgz"), "mychart", "1.0.0"),
+			},
+		}
+		params := params{
+			Chart:          "mychart",
+			Version:        "1.0.0",
+			Bucket:         "my-bucket",
+			Credentials:    "gke-production",
+			AllowOverwrite: true,
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "helm gcs push mychart-1.0.0.tgz gcs-repo --force --retry", runner.commandLines()[len(runner.commands)-1])
+	})
+
+	t.Run("CopiesIndexesAndPushesToGitRepositoryIfBucketIsNotSet", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = os.Mkdir("helm-charts", 0755)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:                        "mychart",
+			Version:                      "1.0.0",
+			RepositoryDirectory:          "helm-charts",
+			RepositoryChartsSubdirectory: "charts",
+			RepositoryURL:                "https://helm.estafette.io/",
+			RepositoryBranch:             "main",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"git config --global user.email bot@estafette.io",
+			"git config --global user.name estafette-bot",
+			"mkdir -p helm-charts/charts",
+			"cp mychart-1.0.0.tgz helm-charts/charts",
+			"git status",
+			"git add --all",
+			"git commit --allow-empty -m mychart v1.0.0",
+			"git push origin main",
+		}, runner.commandLines())
+		index, err := loadRepositoryIndex(filepath.Join("helm-charts", "index.yaml"))
+		assert.Nil(t, err)
+		if entry := index.Get("mychart", "1.0.0"); assert.NotNil(t, entry) {
+			assert.Equal(t, []interface{}{"https://helm.estafette.io/charts/mychart-1.0.0.tgz"}, entry["urls"])
+		}
+	})
+
+	t.Run("LogsInAndPushesToOciRegistryIfRegistryIsSet", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		runner := &fakeCommandRunner{
+			errors: map[string]error{
+				"helm pull": errors.New("exit status 1: Error: europe-docker.pkg.dev/my-project/charts/mychart:1.0.0: not found"),
+			},
+		}
+		params := params{
+			Chart:               "mychart",
+			Version:             "1.0.0",
+			Registry:            "oci://europe-docker.pkg.dev/my-project/charts/",
+			RegistryCredentials: "gke-production",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		commandLines := runner.commandLines()
+		if assert.Equal(t, 3, len(commandLines)) {
+			assert.Equal(t, "helm registry login europe-docker.pkg.dev --username _json_key --password-stdin", commandLines[0])
+			assert.True(t, strings.HasPrefix(commandLines[1], "helm pull oci://europe-docker.pkg.dev/my-project/charts/mychart --version 1.0.0 --destination "))
+			assert.Equal(t, "helm push mychart-1.0.0.tgz oci://europe-docker.pkg.dev/my-project/charts", commandLines[2])
+		}
+		assert.Equal(t, `{"client_email":"sa@my-project.iam.gserviceaccount.com"}`, runner.inputs[0])
+	})
+
+	t.Run("SucceedsWithoutPushIfOciTagHasSameDigest", func(t *testing.T) {
+
+		inTempDir(t)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		runner := &fakeCommandRunner{
+			effects: map[string]func([]string){
+				"helm pull": func(argv []string) {
+					writePackagedChart(t, filepath.Join(argv[len(argv)-1], "mychart-1.0.0.tgz"), "mychart", "1.0.0")
+				},
+			},
+		}
+		params := params{
+			Chart:    "mychart",
+			Version:  "1.0.0",
+			Registry: "oci://europe-docker.pkg.dev/my-project/charts",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(runner.commands))
+	})
+
+	t.Run("ReturnsErrorIfOciTagHasDifferentDigest", func(t *testing.T) {
+
+		inTempDir(t)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		runner := &fakeCommandRunner{
+			effects: map[string]func([]string){
+				"helm pull": func(argv []string) {
+					writePackagedChart(t, filepath.Join(argv[len(argv)-1], "mychart-1.0.0.tgz"), "mychart", "1.0.0-changed")
+				},
+			},
+		}
+		params := params{
+			Chart:    "mychart",
+			Version:  "1.0.0",
+			Registry: "oci://europe-docker.pkg.dev/my-project/charts",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "set allowOverwrite: true to replace it")
+		}
+		assert.Equal(t, 1, len(runner.commands))
+	})
+
+	t.Run("ReturnsErrorWithoutPushIfOciTagCannotBePulled", func(t *testing.T) {
+
+		inTempDir(t)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		runner := &fakeCommandRunner{
+			errors: map[string]error{
+				"helm pull": errors.New("exit status 1: Error: unexpected status from HEAD request to https://europe-docker.pkg.dev/v2/my-project/charts/mychart/manifests/1.0.0: 403 Forbidden"),
+			},
+		}
+		params := params{
+			Chart:    "mychart",
+			Version:  "1.0.0",
+			Registry: "oci://europe-docker.pkg.dev/my-project/charts",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "failed checking whether chart mychart version 1.0.0 is published to oci://europe-docker.pkg.dev/my-project/charts")
+		}
+		assert.Equal(t, 1, len(runner.commands))
+	})
+
+	t.Run("CopiesProvenanceFileToGitRepositoryIfPresent", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = os.Mkdir("helm-charts", 0755)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		_ = ioutil.WriteFile("mychart-1.0.0.tgz.prov", []byte("signature"), 0644)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:                        "mychart",
+			Version:                      "1.0.0",
+			RepositoryDirectory:          "helm-charts",
+			RepositoryChartsSubdirectory: "charts",
+			RepositoryURL:                "https://helm.estafette.io/",
+			RepositoryBranch:             "main",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "cp mychart-1.0.0.tgz.prov helm-charts/charts", runner.commandLines()[4])
+	})
+
+	t.Run("SucceedsWithoutCommitIfGitIndexHasSameDigest", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = os.Mkdir("helm-charts", 0755)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		writeIndex(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")([]string{filepath.Join("helm-charts", "index.yaml")})
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:                        "mychart",
+			Version:                      "1.0.0",
+			RepositoryDirectory:          "helm-charts",
+			RepositoryChartsSubdirectory: "charts",
+			RepositoryURL:                "https://helm.estafette.io/",
+			RepositoryBranch:             "main",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"git config --global user.email bot@estafette.io",
+			"git config --global user.name estafette-bot",
+		}, runner.commandLines())
+	})
+
+	t.Run("ReturnsErrorIfGitIndexHasDifferentDigest", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = os.Mkdir("helm-charts", 0755)
+		writePackagedChart(t, "published.tgz", "mychart", "1.0.0-changed")
+		writeIndex(t, "published.tgz", "mychart", "1.0.0")([]string{filepath.Join("helm-charts", "index.yaml")})
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:                        "mychart",
+			Version:                      "1.0.0",
+			RepositoryDirectory:          "helm-charts",
+			RepositoryChartsSubdirectory: "charts",
+			RepositoryURL:                "https://helm.estafette.io/",
+			RepositoryBranch:             "main",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "chart mychart version 1.0.0 is already published to repository https://helm.estafette.io/ with digest")
+		}
+		assert.Equal(t, 2, len(runner.commands))
+	})
+
+	t.Run("PlansPublishToGitRepositoryWithoutReadingPackageInDryRun", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = os.Mkdir("helm-charts", 0755)
+		writePackagedChart(t, "published.tgz", "mychart", "1.0.0")
+		writeIndex(t, "published.tgz", "mychart", "1.0.0")([]string{filepath.Join("helm-charts", "index.yaml")})
+		indexBefore, _ := ioutil.ReadFile(filepath.Join("helm-charts", "index.yaml"))
+		runner := NewDryRunRunner()
+		params := params{
+			Chart:                        "mychart",
+			Version:                      "1.0.0",
+			RepositoryDirectory:          "helm-charts",
+			RepositoryChartsSubdirectory: "charts",
+			RepositoryURL:                "https://helm.estafette.io/",
+			RepositoryBranch:             "main",
+			DryRun:                       true,
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		indexAfter, _ := ioutil.ReadFile(filepath.Join("helm-charts", "index.yaml"))
+		assert.Equal(t, string(indexBefore), string(indexAfter))
+	})
+
+	t.Run("ReturnsErrorIfMultipleTargetsAreSet", func(t *testing.T) {
+
+		inTempDir(t)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:    "mychart",
+			Version:  "1.0.0",
+			Bucket:   "my-bucket",
+			Registry: "oci://europe-docker.pkg.dev/my-project/charts",
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "parameters bucket and registry are set")
+		}
+		assert.Equal(t, 0, len(runner.commands))
+	})
+
+	t.Run("AppliesChangesOnTopOfMovedBranchAndPushesAgainIfPushIsRejected", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = os.Mkdir("helm-charts", 0755)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		withPushRetryDelay(t)
+		runner := &fakeCommandRunner{
+			failures: map[string]int{
+				"git push":       1,
+				"git merge-base": 1,
+			},
+			effects: map[string]func([]string){
+				"git reset": resetIndex,
+			},
+		}
+		params := params{
+			Chart:                        "mychart",
+			Version:                      "1.0.0",
+			RepositoryDirectory:          "helm-charts",
+			RepositoryChartsSubdirectory: "charts",
+			RepositoryURL:                "https://helm.estafette.io/",
+			RepositoryBranch:             "main",
+			RepositoryPushAttempts:       3,
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"git config --global user.email bot@estafette.io",
+			"git config --global user.name estafette-bot",
+			"mkdir -p helm-charts/charts",
+			"cp mychart-1.0.0.tgz helm-charts/charts",
+			"git status",
+			"git add --all",
+			"git commit --allow-empty -m mychart v1.0.0",
+			"git push origin main",
+			"git fetch origin main",
+			"git merge-base --is-ancestor origin/main HEAD",
+			"git reset --hard origin/main",
+			"mkdir -p helm-charts/charts",
+			"cp mychart-1.0.0.tgz helm-charts/charts",
+			"git status",
+			"git add --all",
+			"git commit --allow-empty -m mychart v1.0.0",
+			"git push origin main",
+		}, runner.commandLines())
+	})
+
+	t.Run("ReturnsErrorWithoutRetryIfPushFailsWhileBranchDidNotMove", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = os.Mkdir("helm-charts", 0755)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		withPushRetryDelay(t)
+		runner := &fakeCommandRunner{
+			failures: map[string]int{
+				"git push": 1,
+			},
+		}
+		params := params{
+			Chart:                        "mychart",
+			Version:                      "1.0.0",
+			RepositoryDirectory:          "helm-charts",
+			RepositoryChartsSubdirectory: "charts",
+			RepositoryURL:                "https://helm.estafette.io/",
+			RepositoryBranch:             "main",
+			RepositoryPushAttempts:       3,
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "git push origin main failed", err.Error())
+		}
+		assert.Equal(t, "git merge-base --is-ancestor origin/main HEAD", runner.commandLines()[len(runner.commands)-1])
+	})
+
+	t.Run("ReturnsErrorAfterLastAttemptIfPushKeepsBeingRejected", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = os.Mkdir("helm-charts", 0755)
+		writePackagedChart(t, "mychart-1.0.0.tgz", "mychart", "1.0.0")
+		withPushRetryDelay(t)
+		runner := &fakeCommandRunner{
+			failures: map[string]int{
+				"git push":       5,
+				"git merge-base": 5,
+			},
+			effects: map[string]func([]string){
+				"git reset": resetIndex,
+			},
+		}
+		params := params{
+			Chart:                        "mychart",
+			Version:                      "1.0.0",
+			RepositoryDirectory:          "helm-charts",
+			RepositoryChartsSubdirectory: "charts",
+			RepositoryURL:                "https://helm.estafette.io/",
+			RepositoryBranch:             "main",
+			RepositoryPushAttempts:       2,
+		}
+
+		// act
+		err := publishAction{}.Run(context.Background(), runner, params)
+
+		if assert.NotNil(t, err) {
+			assert.True(t, errors.Is(err, errPushRejected))
+		}
+		assert.Equal(t, 3, runner.failures["git push"])
+	})
+}
+
+// writeIndex returns a command effect that writes an index containing the package at chartPath as chart version to the path in the last argument
+func writeIndex(t *testing.T, chartPath, chart, version string) func(argv []string) {
+	return func(argv []string) {
+		index := &repositoryIndex{APIVersion: "v1", Entries: map[string][]map[string]interface{}{}}
+		digest, err := fileDigest(chartPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		index.Entries[chart] = []map[string]interface{}{{"name": chart, "version": version, "digest": digest}}
+		if err := index.Save(argv[len(argv)-1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// resetIndex removes the index file written by the previous attempt, like git reset --hard does for a repository without index file
+func resetIndex(argv []string) {
+	_ = os.Remove(filepath.Join("helm-charts", "index.yaml"))
+}
+
+// withPushRetryDelay shortens the delay between push attempts for the duration of the test
+func withPushRetryDelay(t *testing.T) {
+	original := pushRetryDelayMillisecond
+	pushRetryDelayMillisecond = 8
+	t.Cleanup(func() {
+		pushRetryDelayMillisecond = original
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+type purgeAction struct{}
+
+func (purgeAction) Name() string {
+	return "purge"
+}
+
+func (purgeAction) Description() string {
+	return "Removes the pre-release versions of the chart version and the versions outside the retention policy from a cloned git repository or gcs bucket"
+}
+
+func (purgeAction) RequiredParams() []string {
+	return []string{"chart", "version", "repoDir", "repoChartsSubdir", "repoUrl", "repoBranch"}
+}
+
+func (purgeAction) OptionalParams() []string {
+	return []string{"bucket", "credentials", "purgeKeepReleases", "purgePinned", "purgePrereleaseDays", "repoPushAttempts"}
+}
+
+func (purgeAction) Run(ctx context.Context, runner CommandRunner, params params) error {
+	log.Info().Msgf("Purging versions of chart %v according to the retention policy...", params.Chart)
+
+	if params.Bucket != "" {
+		return purgeFromBucket(ctx, runner, params)
+	}
+
+	chartsDirectory := filepath.Join(params.RepositoryDirectory, params.RepositoryChartsSubdirectory)
+	err := runner.RunCommandWithArgs(ctx, "mkdir", []string{"-p", chartsDirectory})
+	if err != nil {
+		return err
+	}
+
+	candidates, err := selectRepositoryPurgeCandidates(params, chartsDirectory)
+	if err != nil {
+		return err
+	}
+	if len(candidates) == 0 {
+		log.Info().Msg("Found 0 versions to purge")
+		return nil
+	}
+	log.Info().Msgf("Found %v versions to purge:\n%v", len(candidates), formatPurgeTable(candidates))
+
+	commitMessage := fmt.Sprintf("purged %v v%v-.+", params.Chart, params.Version)
+	if params.hasRetentionPolicy() {
+		commitMessage = fmt.Sprintf("purged %v versions of %v", len(candidates), params.Chart)
+	}
+
+	return pushRepositoryChanges(ctx, runner, params, commitMessage, func() error {
+		// select again, since versions can have changed after applying the changes on top of a moved branch
+		candidates, err := selectRepositoryPurgeCandidates(params, chartsDirectory)
+		if err != nil {
+			return err
+		}
+
+		files := []string{}
+		for _, c := range candidates {
+			filename := filepath.Join(chartsDirectory, fmt.Sprintf("%v-%v.tgz", params.Chart, c.Version))
+			for _, f := range []string{filename, filename + ".prov"} {
+				if foundation.FileExists(f) {
+					files = append(files, f)
+				}
+			}
+		}
+		sort.Strings(files)
+		if len(files) > 0 {
+			err = runner.RunCommandWithArgs(ctx, "rm", append([]string{"-f"}, files...))
+			if err != nil {
+				return err
+			}
+		}
+
+		log.Info().Msgf("Removing purged versions from index file for repository %v...", params.RepositoryURL)
+		return updateRepositoryIndex(params, func(index *repositoryIndex) error {
+			for _, c := range candidates {
+				if !index.Remove(params.Chart, c.Version) {
+					log.Warn().Msgf("Chart %v version %v is not in the index file", params.Chart, c.Version)
+				}
+			}
+			return nil
+		})
+	})
+}
+
+// selectRepositoryPurgeCandidates applies the retention policy to the versions in the index of the cloned repository and the packages in its charts directory
+func selectRepositoryPurgeCandidates(params params, chartsDirectory string) ([]purgeCandidate, error) {
+	index, err := loadRepositoryIndex(filepath.Join(params.RepositoryDirectory, "index.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	packaged, err := packagedVersions(chartsDirectory, params.Chart)
+	if err != nil {
+		return nil, err
+	}
+	return selectPurgeCandidates(mergeVersions(indexVersions(index, params.Chart), packaged), params, time.Now()), nil
+}
+
+// purgeFromBucket removes the versions outside the retention policy from a gcs bucket with the helm gcs plugin, which updates the index file of the bucket as well
+func purgeFromBucket(ctx context.Context, runner CommandRunner, params params) error {
+	err := initBucketRepository(ctx, runner, params)
+	if err != nil {
+		return err
+	}
+
+	if params.DryRun {
+		log.Info().Msgf("Dry run: skipping retrieving index file from bucket %v, so the versions to purge can't be determined...", params.Bucket)
+		return nil
+	}
+
+	index, err := loadBucketIndex(ctx, runner, params)
+	if err != nil {
+		return fmt.Errorf("failed retrieving index file from bucket %v: %w", params.Bucket, err)
+	}
+	candidates := selectPurgeCandidates(indexVersions(index, params.Chart), params, time.Now())
+	if len(candidates) == 0 {
+		log.Info().Msg("Found 0 versions to purge")
+		return nil
+	}
+	log.Info().Msgf("Found %v versions to purge:\n%v", len(candidates), formatPurgeTable(candidates))
+
+	for _, c := range candidates {
+		log.Info().Msgf("Removing chart %v version %v from bucket %v...", params.Chart, c.Version, params.Bucket)
+		err = runner.RunCommandWithArgs(ctx, "helm", []string{"gcs", "rm", params.Chart, "gcs-repo", "--version", c.Version})
+		if err != nil {
+			return err
+		}
+
+		// only signed charts have a provenance file next to them, so failing to remove it is expected
+		provenanceObject := fmt.Sprintf("gs://%v/%v-%v.tgz.prov", params.Bucket, params.Chart, c.Version)
+		err = runner.RunCommandWithArgs(ctx, "gcloud", []string{"storage", "rm", provenanceObject})
+		if err != nil {
+			log.Info().Msgf("No provenance file %v removed: %v", provenanceObject, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPurgeAction(t *testing.T) {
+	t.Run("RemovesPrereleaseFilesAndTheirIndexEntriesOnly", func(t *testing.T) {
+
+		inTempDir(t)
+		chartsDirectory := filepath.Join("helm-charts", "charts")
+		_ = os.MkdirAll(chartsDirectory, 0755)
+		index, _ := loadRepositoryIndex(filepath.Join("helm-charts", "index.yaml"))
+		for _, version := range []string{"1.0.0-beta", "1.0.0-rc.1", "1.0.0", "0.9.0-beta"} {
+			chartPath := filepath.Join(chartsDirectory, "mychart-"+version+".tgz")
+			writePackagedChart(t, chartPath, "mychart", version)
+			_ = index.Add(chartPath, chartURL("https://helm.estafette.io/", filepath.Join("charts", filepath.Base(chartPath))))
+		}
+		_ = index.Save(filepath.Join("helm-charts", "index.yaml"))
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:                        "mychart",
+			Version:                      "1.0.0",
+			RepositoryDirectory:          "helm-charts",
+			RepositoryChartsSubdirectory: "charts",
+			RepositoryURL:                "https://helm.estafette.io/",
+			RepositoryBranch:             "main",
+		}
+
+		// act
+		err := purgeAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{
+			"mkdir -p helm-charts/charts",
+			"git config --global user.email bot@estafette.io",
+			"git config --global user.name estafette-bot",
+			"rm -f helm-charts/charts/mychart-1.0.0-beta.tgz helm-charts/charts/mychart-1.0.0-rc.1.tgz",
+			"git status",
+			"git add --all",
+			"git commit --allow-empty -m purged mychart v1.0.0-.+",
+			"git push origin main",
+		}, runner.commandLines())
+		saved, err := loadRepositoryIndex(filepath.Join("helm-charts", "index.yaml"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1.0.0", "0.9.0-beta"}, saved.Versions("mychart"))
+	})
+
+	t.Run("RemovesReleasesOutsideRetentionPolicyWithTheirProvenanceFiles", func(t *testing.T) {
+
+		inTempDir(t)
+		chartsDirectory := filepath.Join("helm-charts", "charts")
+		_ = os.MkdirAll(chartsDirectory, 0755)
+		index, _ := loadRepositoryIndex(filepath.Join("helm-charts", "index.yaml"))
+		for _, version := range []string{"1.0.0", "1.0.1", "1.0.2", "1.1.0"} {
+			chartPath := filepath.Join(chartsDirectory, "mychart-"+version+".tgz")
+			writePackagedChart(t, chartPath, "mychart", version)
+			_ = ioutil.WriteFile(chartPath+".prov", []byte("signature"), 0644)
+			_ = index.Add(chartPath, chartURL("https://helm.estafette.io/", filepath.Join("charts", filepath.Base(chartPath))))
+		}
+		_ = index.Save(filepath.Join("helm-charts", "index.yaml"))
+		writePackagedChart(t, filepath.Join(chartsDirectory, "mychart-sidecar-0.1.0.tgz"), "mychart-sidecar", "0.1.0")
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:                        "mychart",
+			Version:                      "1.1.0",
+			PurgeKeepReleases:            1,
+			PurgePinned:                  []string{"1.0.0"},
+			RepositoryDirectory:          "helm-charts",
+			RepositoryChartsSubdirectory: "charts",
+			RepositoryURL:                "https://helm.estafette.io/",
+			RepositoryBranch:             "main",
+		}
+
+		// act
+		err := purgeAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		commandLines := runner.commandLines()
+		if assert.Equal(t, 8, len(commandLines)) {
+			assert.Equal(t, "rm -f helm-charts/charts/mychart-1.0.1.tgz helm-charts/charts/mychart-1.0.1.tgz.prov", commandLines[3])
+			assert.Equal(t, "git commit --allow-empty -m purged 1 versions of mychart", commandLines[6])
+		}
+		saved, err := loadRepositoryIndex(filepath.Join("helm-charts", "index.yaml"))
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1.1.0", "1.0.2", "1.0.0"}, saved.Versions("mychart"))
+	})
+
+	t.Run("DoesNotPushIfNoVersionsAreOutsideRetentionPolicy", func(t *testing.T) {
+
+		inTempDir(t)
+		runner := &fakeCommandRunner{}
+		params := params{
+			Chart:                        "mychart",
+			Version:                      "1.0.0",
+			PurgeKeepReleases:            3,
+			RepositoryDirectory:          "helm-charts",
+			RepositoryChartsSubdirectory: "charts",
+			RepositoryURL:                "https://helm.estafette.io/",
+			RepositoryBranch:             "main",
+		}
+
+		// act
+		err := purgeAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"mkdir -p helm-charts/charts"}, runner.commandLines())
+	})
+	t.Run("RemovesVersionsOutsideRetentionPolicyFromBucket", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		runner := &fakeCommandRunner{
+			effects: map[string]func([]string){
+				"gcloud storage cp": func(argv []string) {
+					index := &repositoryIndex{APIVersion: "v1", Entries: map[string][]map[string]interface{}{}}
+					for _, version := range []string{"1.1.0", "1.1.0-beta", "1.0.1", "1.0.0", "1.0.0-beta"} {
+						index.Entries["mychart"] = append(index.Entries["mychart"], map[string]interface{}{"name": "mychart", "version": version})
+					}
+					_ = index.Save(argv[len(argv)-1])
+				},
+			},
+			failures: map[string]int{
+				"gcloud storage rm gs://my-bucket/mychart-1.0.0.tgz.prov": 1,
+			},
+		}
+		params := params{
+			Chart:             "mychart",
+			Version:           "1.1.0",
+			PurgeKeepReleases: 1,
+			PurgePinned:       []string{"1.0.0-beta"},
+			Bucket:            "my-bucket",
+			Credentials:       "gke-production",
+		}
+
+		// act
+		err := purgeAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		commandLines := runner.commandLines()
+		if assert.Equal(t, 8, len(commandLines)) {
+			assert.Equal(t, "helm repo add gcs-repo gs://my-bucket", commandLines[2])
+			assert.True(t, strings.HasPrefix(commandLines[3], "gcloud storage cp gs://my-bucket/index.yaml "))
+			assert.Equal(t, "helm gcs rm mychart gcs-repo --version 1.1.0-beta", commandLines[4])
+			assert.Equal(t, "gcloud storage rm gs://my-bucket/mychart-1.1.0-beta.tgz.prov", commandLines[5])
+			assert.Equal(t, "helm gcs rm mychart gcs-repo --version 1.0.0", commandLines[6])
+			assert.Equal(t, "gcloud storage rm gs://my-bucket/mychart-1.0.0.tgz.prov", commandLines[7])
+		}
+	})
+
+	t.Run("DoesNotRetrieveBucketIndexInDryRun", func(t *testing.T) {
+
+		inTempDir(t)
+		withCredentials(t)
+		runner := NewDryRunRunner()
+		params := params{
+			Chart:       "mychart",
+			Version:     "1.1.0",
+			Bucket:      "my-bucket",
+			Credentials: "gke-production",
+			DryRun:      true,
+		}
+
+		// act
+		err := purgeAction{}.Run(context.Background(), runner, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "helm repo add gcs-repo gs://my-bucket", runner.commands[len(runner.commands)-1])
+	})
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"gopkg.in/yaml.v2"
+)
+
+// repositoryIndex is the index.yaml file of a chart repository; entries are kept as generic maps so fields unknown to this extension survive a load and save
+type repositoryIndex struct {
+	Annotations map[string]string                   `yaml:"annotations,omitempty"`
+	APIVersion  string                              `yaml:"apiVersion"`
+	Entries     map[string][]map[string]interface{} `yaml:"entries"`
+	Generated   string                              `yaml:"generated"`
+	ServerInfo  map[string]interface{}              `yaml:"serverInfo,omitempty"`
+}
+
+// loadRepositoryIndex reads the index file at path, or returns an empty index if it doesn't exist yet
+func loadRepositoryIndex(indexPath string) (*repositoryIndex, error) {
+	index := &repositoryIndex{APIVersion: "v1", Entries: map[string][]map[string]interface{}{}}
+	if !foundation.FileExists(indexPath) {
+		return index, nil
+	}
+
+	data, err := ioutil.ReadFile(indexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading index file at %v: %w", indexPath, err)
+	}
+	err = yaml.Unmarshal(data, index)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling index file at %v: %w", indexPath, err)
+	}
+	if index.Entries == nil {
+		index.Entries = map[string][]map[string]interface{}{}
+	}
+
+	return index, nil
+}
+
+// Save writes the index to path, updating its generated timestamp
+func (i *repositoryIndex) Save(indexPath string) error {
+	i.Generated = time.Now().UTC().Format(time.RFC3339Nano)
+	data, err := yaml.Marshal(i)
+	if err != nil {
+		return fmt.Errorf("failed marshalling index file: %w", err)
+	}
+	err = ioutil.WriteFile(indexPath, data, 0644)
+	if err != nil {
+		return fmt.Errorf("failed writing index file at %v: %w", indexPath, err)
+	}
+	return nil
+}
+
+// Add adds the packaged chart at chartPath with its metadata and digest, replacing the entry for the same version if it exists, and keeps the versions sorted from newest to oldest
+func (i *repositoryIndex) Add(chartPath, url string) error {
+	metadata, err := readPackagedChartMetadata(chartPath)
+	if err != nil {
+		return err
+	}
+	digest, err := fileDigest(chartPath)
+	if err != nil {
+		return err
+	}
+
+	name, _ := metadata["name"].(string)
+	version, _ := metadata["version"].(string)
+	if name == "" || version == "" {
+		return fmt.Errorf("chart %v has no name or version in its Chart.yaml", chartPath)
+	}
+
+	entry := map[string]interface{}{}
+	for key, value := range metadata {
+		entry[key] = value
+	}
+	entry["created"] = time.Now().UTC().Format(time.RFC3339Nano)
+	entry["digest"] = digest
+	entry["urls"] = []string{url}
+
+	i.Remove(name, version)
+	i.Entries[name] = append(i.Entries[name], entry)
+	sort.SliceStable(i.Entries[name], func(a, b int) bool {
+		return compareVersions(entryVersion(i.Entries[name][a]), entryVersion(i.Entries[name][b])) > 0
+	})
+
+	return nil
+}
+
+// Remove removes the entry for a chart version; it returns false if the index has no such entry
+func (i *repositoryIndex) Remove(name, version string) bool {
+	entries := i.Entries[name]
+	for j, entry := range entries {
+		if entryVersion(entry) == version {
+			i.Entries[name] = append(entries[:j], entries[j+1:]...)
+			if len(i.Entries[name]) == 0 {
+				delete(i.Entries, name)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Get returns the entry for a chart version, or nil if the index has no such entry
+func (i *repositoryIndex) Get(name, version string) map[string]interface{} {
+	for _, entry := range i.Entries[name] {
+		if entryVersion(entry) == version {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Versions returns the versions of a chart in the order of the index
+func (i *repositoryIndex) Versions(name string) (versions []string) {
+	for _, entry := range i.Entries[name] {
+		versions = append(versions, entryVersion(entry))
+	}
+	return
+}
+
+func entryVersion(entry map[string]interface{}) string {
+	return fmt.Sprintf("%v", entry["version"])
+}
+
+// chartURL returns the url of a chart in a repository at a path relative to the repository root, like helm repo index --url does
+func chartURL(repositoryURL, relativePath string) string {
+	return strings.TrimSuffix(repositoryURL, "/") + "/" + path.Clean(strings.ReplaceAll(relativePath, string(os.PathSeparator), "/"))
+}
+
+// readPackagedChartMetadata returns the contents of the top level Chart.yaml in a packaged chart
+func readPackagedChartMetadata(chartPath string) (map[string]interface{}, error) {
+	file, err := os.Open(chartPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed opening chart %v: %w", chartPath, err)
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading chart %v: %w", chartPath, err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed reading chart %v: %w", chartPath, err)
+		}
+
+		// only the Chart.yaml of the chart itself, not the ones of its dependencies in the charts directory
+		parts := strings.Split(path.Clean(header.Name), "/")
+		if len(parts) != 2 || parts[1] != "Chart.yaml" {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(tarReader)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading Chart.yaml from chart %v: %w", chartPath, err)
+		}
+		metadata := map[string]interface{}{}
+		err = yaml.Unmarshal(data, &metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed unmarshalling Chart.yaml from chart %v: %w", chartPath, err)
+		}
+		return metadata, nil
+	}
+
+	return nil, fmt.Errorf("chart %v has no Chart.yaml", chartPath)
+}
+
+// fileDigest returns the hex encoded sha256 digest of a file, as used in the digest field of the index
+func fileDigest(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed opening %v: %w", filePath, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", fmt.Errorf("failed computing digest for %v: %w", filePath, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writePackagedChart creates a packaged chart like helm package does, with a dependency in its charts directory
+func writePackagedChart(t *testing.T, chartPath, name, version string) {
+	file, err := os.Create(chartPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	files := map[string]string{
+		name + "/Chart.yaml":                   fmt.Sprintf("apiVersion: v2\nname: %v\nversion: %v\nappVersion: %v\ndescription: My chart\n", name, version, version),
+		name + "/charts/postgresql/Chart.yaml": "apiVersion: v2\nname: postgresql\nversion: 10.0.0\n",
+	}
+	for _, fileName := range []string{name + "/charts/postgresql/Chart.yaml", name + "/Chart.yaml"} {
+		content := files[fileName]
+		if err := tarWriter.WriteHeader(&tar.Header{Name: fileName, Mode: 0644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepositoryIndex(t *testing.T) {
+	t.Run("AddsChartVersionWithMetadataDigestAndUrlSortedBySemver", func(t *testing.T) {
+
+		dir := t.TempDir()
+		indexPath := filepath.Join(dir, "index.yaml")
+		_ = ioutil.WriteFile(indexPath, []byte(`apiVersion: v1
+entries:
+  mychart:
+  - apiVersion: v2
+    created: "2020-01-01T00:00:00Z"
+    digest: abc
+    name: mychart
+    urls:
+    - https://helm.estafette.io/charts/mychart-1.10.0.tgz
+    version: 1.10.0
+  - apiVersion: v2
+    created: "2020-01-01T00:00:00Z"
+    digest: def
+    name: mychart
+    urls:
+    - https://helm.estafette.io/charts/mychart-1.2.0.tgz
+    version: 1.2.0
+  otherchart:
+  - apiVersion: v2
+    created: "2019-01-01T00:00:00Z"
+    digest: ghi
+    keywords:
+    - other
+    name: otherchart
+    urls:
+    - https://helm.estafette.io/charts/otherchart-0.1.0.tgz
+    version: 0.1.0
+generated: "2020-01-01T00:00:00Z"
+`), 0644)
+		chartPath := filepath.Join(dir, "mychart-1.3.0-beta.tgz")
+		writePackagedChart(t, chartPath, "mychart", "1.3.0-beta")
+		digest, _ := fileDigest(chartPath)
+		index, err := loadRepositoryIndex(indexPath)
+		assert.Nil(t, err)
+
+		// act
+		err = index.Add(chartPath, chartURL("https://helm.estafette.io/", "charts/mychart-1.3.0-beta.tgz"))
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1.10.0", "1.3.0-beta", "1.2.0"}, index.Versions("mychart"))
+		entry := index.Get("mychart", "1.3.0-beta")
+		if assert.NotNil(t, entry) {
+			assert.Equal(t, "My chart", entry["description"])
+			assert.Equal(t, digest, entry["digest"])
+			assert.Equal(t, []string{"https://helm.estafette.io/charts/mychart-1.3.0-beta.tgz"}, entry["urls"])
+		}
+
+		err = index.Save(indexPath)
+		assert.Nil(t, err)
+		saved, err := loadRepositoryIndex(indexPath)
+		assert.Nil(t, err)
+		assert.Equal(t, "2020-01-01T00:00:00Z", saved.Get("mychart", "1.2.0")["created"])
+		assert.Equal(t, []interface{}{"other"}, saved.Get("otherchart", "0.1.0")["keywords"])
+	})
+
+	t.Run("ReplacesExistingEntryForSameVersion", func(t *testing.T) {
+
+		dir := t.TempDir()
+		chartPath := filepath.Join(dir, "mychart-1.0.0.tgz")
+		writePackagedChart(t, chartPath, "mychart", "1.0.0")
+		index, _ := loadRepositoryIndex(filepath.Join(dir, "index.yaml"))
+		_ = index.Add(chartPath, "https://helm.estafette.io/charts/mychart-1.0.0.tgz")
+
+		// act
+		err := index.Add(chartPath, "https://helm.estafette.io/charts/mychart-1.0.0.tgz")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"1.0.0"}, index.Versions("mychart"))
+	})
+
+	t.Run("RemovesChartVersionAndEmptyChart", func(t *testing.T) {
+
+		index := &repositoryIndex{Entries: map[string][]map[string]interface{}{
+			"mychart": {{"version": "1.0.0"}, {"version": "1.0.0-beta"}},
+		}}
+
+		// act
+		removed := index.Remove("mychart", "1.0.0-beta") && index.Remove("mychart", "1.0.0")
+
+		assert.True(t, removed)
+		assert.False(t, index.Remove("mychart", "2.0.0"))
+		_, exists := index.Entries["mychart"]
+		assert.False(t, exists)
+	})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// publishedVersion is a version of a chart in a repository, with the time it was added to the index if known
+type publishedVersion struct {
+	Version string
+	Created time.Time
+}
+
+// purgeCandidate is a published version selected for removal by the retention policy
+type purgeCandidate struct {
+	publishedVersion
+	Reason string
+}
+
+// hasRetentionPolicy returns true if any of the purge parameters beyond the default pre-release purge is set
+func (p params) hasRetentionPolicy() bool {
+	return p.PurgeKeepReleases > 0 || p.PurgePrereleaseDays > 0
+}
+
+// indexVersions returns the versions of a chart in the index with their created timestamps
+func indexVersions(index *repositoryIndex, chart string) (versions []publishedVersion) {
+	for _, entry := range index.Entries[chart] {
+		v := publishedVersion{Version: entryVersion(entry)}
+		switch created := entry["created"].(type) {
+		case time.Time:
+			v.Created = created
+		case string:
+			v.Created, _ = time.Parse(time.RFC3339Nano, created)
+		}
+		versions = append(versions, v)
+	}
+	return
+}
+
+// packagedVersions returns the versions of a chart packaged as <chart>-<version>.tgz in a directory, skipping the files of charts with a name starting with the same prefix
+func packagedVersions(directory, chart string) ([]publishedVersion, error) {
+	filesGlob := filepath.Join(directory, chart+"-*.tgz")
+	files, err := filepath.Glob(filesGlob)
+	if err != nil {
+		return nil, fmt.Errorf("failed globbing %v: %w", filesGlob, err)
+	}
+
+	versions := []publishedVersion{}
+	for _, f := range files {
+		version := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(f), chart+"-"), ".tgz")
+		if semverRegex.MatchString(version) {
+			versions = append(versions, publishedVersion{Version: version})
+		}
+	}
+	return versions, nil
+}
+
+// mergeVersions returns the versions in a followed by those in b that aren't in a
+func mergeVersions(a, b []publishedVersion) []publishedVersion {
+	merged := append([]publishedVersion{}, a...)
+	for _, v := range b {
+		found := false
+		for _, m := range a {
+			if m.Version == v.Version {
+				found = true
+				break
+			}
+		}
+		if !found {
+			merged = append(merged, v)
+		}
+	}
+	return merged
+}
+
+// selectPurgeCandidates applies the retention policy to the published versions: pre-releases of the version being released are always purged, pre-releases older than purgePrereleaseDays and releases beyond the newest purgeKeepReleases per major and minor version as well; versions matching purgePinned are never purged
+func selectPurgeCandidates(versions []publishedVersion, params params, now time.Time) (candidates []purgeCandidate) {
+
+	type parsedVersion struct {
+		publishedVersion
+		semver semanticVersion
+	}
+	parsed := []parsedVersion{}
+	for _, v := range versions {
+		semver, err := parseSemanticVersion(v.Version)
+		if err != nil {
+			continue
+		}
+		parsed = append(parsed, parsedVersion{v, semver})
+	}
+	sort.SliceStable(parsed, func(i, j int) bool {
+		return parsed[i].semver.Compare(parsed[j].semver) > 0
+	})
+
+	releasesPerMinor := map[string]int{}
+	for _, v := range parsed {
+		minor := fmt.Sprintf("%v.%v", v.semver.Major, v.semver.Minor)
+		if !v.semver.IsPrerelease() {
+			releasesPerMinor[minor]++
+		}
+
+		if isPinnedVersion(v.Version, params.PurgePinned) {
+			continue
+		}
+
+		reason := ""
+		switch {
+		case strings.HasPrefix(v.Version, params.Version+"-"):
+			reason = fmt.Sprintf("pre-release of %v", params.Version)
+		case v.semver.IsPrerelease() && params.PurgePrereleaseDays > 0 && !v.Created.IsZero() && now.Sub(v.Created) > time.Duration(params.PurgePrereleaseDays)*24*time.Hour:
+			reason = fmt.Sprintf("pre-release older than %v days", params.PurgePrereleaseDays)
+		case !v.semver.IsPrerelease() && params.PurgeKeepReleases > 0 && releasesPerMinor[minor] > params.PurgeKeepReleases:
+			reason = fmt.Sprintf("more than %v releases of %v", params.PurgeKeepReleases, minor)
+		}
+		if reason != "" {
+			candidates = append(candidates, purgeCandidate{v.publishedVersion, reason})
+		}
+	}
+
+	return
+}
+
+// isPinnedVersion returns true if the version equals or matches a glob pattern like 1.2.* in the pinned list
+func isPinnedVersion(version string, pinned []string) bool {
+	for _, pattern := range pinned {
+		if pattern == version {
+			return true
+		}
+		if matched, err := path.Match(pattern, version); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// formatPurgeTable returns a table listing the versions to purge with their creation date and why they're purged
+func formatPurgeTable(candidates []purgeCandidate) string {
+	var sb strings.Builder
+	w := tabwriter.NewWriter(&sb, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tCREATED\tREASON")
+	for _, c := range candidates {
+		created := "unknown"
+		if !c.Created.IsZero() {
+			created = c.Created.UTC().Format("2006-01-02")
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\n", c.Version, created, c.Reason)
+	}
+	w.Flush()
+	return sb.String()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectPurgeCandidates(t *testing.T) {
+
+	now := time.Date(2023, 6, 30, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(days int) time.Time {
+		return now.Add(-time.Duration(days) * 24 * time.Hour)
+	}
+
+	t.Run("ReturnsPrereleasesOfVersionOnlyWithoutRetentionPolicy", func(t *testing.T) {
+
+		versions := []publishedVersion{{Version: "1.1.0"}, {Version: "1.1.0-beta"}, {Version: "1.0.0"}, {Version: "1.0.0-beta"}}
+
+		// act
+		candidates := selectPurgeCandidates(versions, params{Version: "1.1.0"}, now)
+
+		assert.Equal(t, []purgeCandidate{{publishedVersion{Version: "1.1.0-beta"}, "pre-release of 1.1.0"}}, candidates)
+	})
+
+	t.Run("KeepsNewestReleasesPerMajorAndMinorVersion", func(t *testing.T) {
+
+		versions := []publishedVersion{{Version: "1.0.0"}, {Version: "1.0.2"}, {Version: "1.0.1"}, {Version: "1.1.0"}, {Version: "2.0.0"}, {Version: "1.0.3-beta"}}
+
+		// act
+		candidates := selectPurgeCandidates(versions, params{Version: "2.0.0", PurgeKeepReleases: 2}, now)
+
+		assert.Equal(t, []purgeCandidate{{publishedVersion{Version: "1.0.0"}, "more than 2 releases of 1.0"}}, candidates)
+	})
+
+	t.Run("ReturnsPrereleasesOlderThanMaximumAge", func(t *testing.T) {
+
+		versions := []publishedVersion{{Version: "1.1.0-beta.2", Created: daysAgo(5)}, {Version: "1.1.0-beta.1", Created: daysAgo(40)}, {Version: "1.0.0", Created: daysAgo(100)}, {Version: "0.9.0-rc"}}
+
+		// act
+		candidates := selectPurgeCandidates(versions, params{Version: "1.0.0", PurgePrereleaseDays: 30}, now)
+
+		assert.Equal(t, []purgeCandidate{{publishedVersion{Version: "1.1.0-beta.1", Created: daysAgo(40)}, "pre-release older than 30 days"}}, candidates)
+	})
+
+	t.Run("NeverReturnsPinnedVersions", func(t *testing.T) {
+
+		versions := []publishedVersion{{Version: "1.0.2"}, {Version: "1.0.1"}, {Version: "1.0.0"}, {Version: "1.1.0-beta", Created: daysAgo(40)}, {Version: "1.1.0-rc"}}
+
+		// act
+		candidates := selectPurgeCandidates(versions, params{Version: "1.1.0", PurgeKeepReleases: 1, PurgePrereleaseDays: 30, PurgePinned: []string{"1.0.0", "1.1.0-*"}}, now)
+
+		assert.Equal(t, []purgeCandidate{{publishedVersion{Version: "1.0.1"}, "more than 1 releases of 1.0"}}, candidates)
+	})
+}
+
+func TestFormatPurgeTable(t *testing.T) {
+	t.Run("ReturnsAlignedTableWithCreationDateAndReason", func(t *testing.T) {
+
+		candidates := []purgeCandidate{
+			{publishedVersion{Version: "1.0.0-beta", Created: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)}, "pre-release older than 30 days"},
+			{publishedVersion{Version: "0.9.0"}, "more than 2 releases of 0.9"},
+		}
+
+		// act
+		table := formatPurgeTable(candidates)
+
+		assert.Equal(t, "VERSION     CREATED     REASON\n1.0.0-beta  2023-05-01  pre-release older than 30 days\n0.9.0       unknown     more than 2 releases of 0.9\n", table)
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CommandRunner executes the helm, kubectl, gcloud and git commands for the actions, so they can be replaced in tests
+type CommandRunner interface {
+	// RunCommandWithArgs runs a single command and passes the arguments as is, without any shell interpretation; it returns an error if command execution failed
+	RunCommandWithArgs(ctx context.Context, command string, args []string) error
+	// RunCommandInDirectoryWithArgs runs a single command from the specified directory and passes the arguments as is; it returns an error if command execution failed
+	RunCommandInDirectoryWithArgs(ctx context.Context, dir string, command string, args []string) error
+	// RunCommandWithArgsAndStdin runs a single command and writes stdin to its standard input, so secrets like passwords and values never show up in the arguments, logs or on disk
+	RunCommandWithArgsAndStdin(ctx context.Context, command string, args []string, stdin string) error
+	// GetCommandWithArgsOutput runs a single command and returns its standard output instead of printing it, so output like decrypted values never shows up in the logs
+	GetCommandWithArgsOutput(ctx context.Context, command string, args []string) (string, error)
+}
+
+// NewCommandRunner returns a CommandRunner that executes the commands with their output going to stdout and stderr
+func NewCommandRunner() CommandRunner {
+	return &commandRunner{}
+}
+
+type commandRunner struct{}
+
+func (r *commandRunner) RunCommandWithArgs(ctx context.Context, command string, args []string) error {
+	return r.run(ctx, "", command, args, nil, nil)
+}
+
+func (r *commandRunner) RunCommandInDirectoryWithArgs(ctx context.Context, dir string, command string, args []string) error {
+	return r.run(ctx, dir, command, args, nil, nil)
+}
+
+func (r *commandRunner) RunCommandWithArgsAndStdin(ctx context.Context, command string, args []string, stdin string) error {
+	return r.run(ctx, "", command, args, strings.NewReader(stdin), nil)
+}
+
+func (r *commandRunner) GetCommandWithArgsOutput(ctx context.Context, command string, args []string) (string, error) {
+	var stdout bytes.Buffer
+	err := r.run(ctx, "", command, args, nil, &stdout)
+	return stdout.String(), err
+}
+
+// run executes the command with its output masked by the secretMasker before it goes to stdout and stderr, unless stdout is captured
+func (r *commandRunner) run(ctx context.Context, dir string, command string, args []string, stdin io.Reader, stdout io.Writer) error {
+	log.Debug().Msgf("> %v %v", command, strings.Join(args, " "))
+
+	if stdout == nil {
+		maskedStdout := secretMasker.Writer(os.Stdout)
+		defer maskedStdout.Close()
+		stdout = maskedStdout
+	}
+	lastStderrLine := &lastLineWriter{}
+	stderr := secretMasker.Writer(io.MultiWriter(os.Stderr, lastStderrLine))
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Dir = dir
+	cmd.Env = os.Environ()
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	stderr.Close()
+	if err != nil && lastStderrLine.Line() != "" {
+		// the last line of stderr usually has the reason, like the Error: line of helm, so callers can tell failures apart
+		return fmt.Errorf("%w: %v", err, lastStderrLine.Line())
+	}
+	return err
+}
+
+// lastLineWriter remembers the last non-empty line written to it
+type lastLineWriter struct {
+	partial string
+	last    string
+}
+
+func (w *lastLineWriter) Write(p []byte) (int, error) {
+	lines := strings.Split(w.partial+string(p), "\n")
+	w.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if strings.TrimSpace(line) != "" {
+			w.last = strings.TrimSpace(line)
+		}
+	}
+	return len(p), nil
+}
+
+// Line returns the last non-empty line, including a last line without newline
+func (w *lastLineWriter) Line() string {
+	if strings.TrimSpace(w.partial) != "" {
+		return strings.TrimSpace(w.partial)
+	}
+	return w.last
+}
+
+// dryRunRunner records and logs the commands instead of executing them, so the plan for a dry run can be printed
+type dryRunRunner struct {
+	commands []string
+}
+
+// NewDryRunRunner returns a CommandRunner that only records the commands it's asked to run
+func NewDryRunRunner() *dryRunRunner {
+	return &dryRunRunner{}
+}
+
+func (r *dryRunRunner) RunCommandWithArgs(ctx context.Context, command string, args []string) error {
+	return r.record(formatCommandLine(command, args))
+}
+
+func (r *dryRunRunner) RunCommandInDirectoryWithArgs(ctx context.Context, dir string, command string, args []string) error {
+	return r.record(fmt.Sprintf("(cd %v && %v)", quoteArg(dir), formatCommandLine(command, args)))
+}
+
+func (r *dryRunRunner) RunCommandWithArgsAndStdin(ctx context.Context, command string, args []string, stdin string) error {
+	return r.record(formatCommandLine(command, args) + " <<< '***'")
+}
+
+func (r *dryRunRunner) GetCommandWithArgsOutput(ctx context.Context, command string, args []string) (string, error) {
+	return "", r.record(formatCommandLine(command, args))
+}
+
+func (r *dryRunRunner) record(commandLine string) error {
+	log.Info().Msgf("Dry run: %v", commandLine)
+	r.commands = append(r.commands, commandLine)
+	return nil
+}
+
+// Plan returns the numbered list of recorded commands in the order they would have been executed
+func (r *dryRunRunner) Plan() string {
+	if len(r.commands) == 0 {
+		return "no commands\n"
+	}
+	var sb strings.Builder
+	for i, c := range r.commands {
+		sb.WriteString(fmt.Sprintf("%3v. %v\n", i+1, c))
+	}
+	return sb.String()
+}
+
+// formatCommandLine joins the command and arguments, quoting arguments so the line can be copied into a shell
+func formatCommandLine(command string, args []string) string {
+	quoted := []string{quoteArg(command)}
+	for _, a := range args {
+		quoted = append(quoted, quoteArg(a))
+	}
+	return strings.Join(quoted, " ")
+}
+
+func quoteArg(arg string) string {
+	if arg == "" {
+		return "''"
+	}
+	if strings.IndexFunc(arg, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=,@%+", r))
+	}) == -1 {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
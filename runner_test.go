@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeCommandRunner records the argv of every command instead of executing it
+type fakeCommandRunner struct {
+	commands [][]string
+	// directories contains the directory each command ran from, empty for the working directory
+	directories []string
+	// inputs contains the standard input each command received, empty if none
+	inputs []string
+	// errors returns the error for the first command that starts with the key
+	errors map[string]error
+	// effects simulates the side effects of commands that start with the key, like files they write
+	effects map[string]func(argv []string)
+	// failures makes the next n commands that start with the key fail, before they succeed again
+	failures map[string]int
+	// outputs returns the standard output for commands that start with the key
+	outputs map[string]string
+}
+
+func (r *fakeCommandRunner) RunCommandWithArgs(ctx context.Context, command string, args []string) error {
+	return r.RunCommandInDirectoryWithArgs(ctx, "", command, args)
+}
+
+func (r *fakeCommandRunner) RunCommandInDirectoryWithArgs(ctx context.Context, dir string, command string, args []string) error {
+	return r.run(dir, command, args, "")
+}
+
+func (r *fakeCommandRunner) RunCommandWithArgsAndStdin(ctx context.Context, command string, args []string, stdin string) error {
+	return r.run("", command, args, stdin)
+}
+
+func (r *fakeCommandRunner) GetCommandWithArgsOutput(ctx context.Context, command string, args []string) (string, error) {
+	err := r.run("", command, args, "")
+	if err != nil {
+		return "", err
+	}
+	commandLine := strings.Join(append([]string{command}, args...), " ")
+	for prefix, output := range r.outputs {
+		if strings.HasPrefix(commandLine, prefix) {
+			return output, nil
+		}
+	}
+	return "", nil
+}
+
+func (r *fakeCommandRunner) run(dir string, command string, args []string, stdin string) error {
+	argv := append([]string{command}, args...)
+	r.commands = append(r.commands, argv)
+	r.directories = append(r.directories, dir)
+	r.inputs = append(r.inputs, stdin)
+
+	commandLine := strings.Join(argv, " ")
+	for prefix, effect := range r.effects {
+		if strings.HasPrefix(commandLine, prefix) {
+			effect(argv)
+		}
+	}
+	for prefix, n := range r.failures {
+		if n > 0 && strings.HasPrefix(commandLine, prefix) {
+			r.failures[prefix] = n - 1
+			return fmt.Errorf("%v failed", commandLine)
+		}
+	}
+	for prefix, err := range r.errors {
+		if strings.HasPrefix(commandLine, prefix) {
+			return err
+		}
+	}
+	return nil
+}
+
+// commandLines returns the recorded commands joined by spaces for easier comparison
+func (r *fakeCommandRunner) commandLines() (lines []string) {
+	for _, argv := range r.commands {
+		lines = append(lines, strings.Join(argv, " "))
+	}
+	return
+}
+
+// inTempDir changes the working directory to a new temporary directory for the duration of the test
+func inTempDir(t *testing.T) string {
+	dir := t.TempDir()
+	workingDirectory, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(workingDirectory)
+	})
+	return dir
+}
+
+// withCredentials injects a kubernetes-engine credential named gke-production and stores the keyfile in a temporary directory
+func withCredentials(t *testing.T) {
+	dir := t.TempDir()
+
+	credentialsFile := filepath.Join(dir, "kubernetes_engine.json")
+	credentials := `[{"name":"gke-production","type":"kubernetes-engine","additionalProperties":{"project":"my-project","cluster":"my-cluster","zone":"europe-west1-c","serviceAccountKeyfile":"{\"client_email\":\"sa@my-project.iam.gserviceaccount.com\"}"}}]`
+	if err := ioutil.WriteFile(credentialsFile, []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	originalCredentialsPath, originalKeyfilePath := *credentialsPath, serviceAccountKeyfilePath
+	*credentialsPath = credentialsFile
+	serviceAccountKeyfilePath = filepath.Join(dir, "key-file.json")
+	t.Cleanup(func() {
+		*credentialsPath = originalCredentialsPath
+		serviceAccountKeyfilePath = originalKeyfilePath
+		initializedCredential = ""
+	})
+}
+
+func TestCommandRunner(t *testing.T) {
+	t.Run("ReturnsErrorWithLastLineOfStderr", func(t *testing.T) {
+
+		// act
+		err := NewCommandRunner().RunCommandWithArgs(context.Background(), "sh", []string{"-c", "echo pulling >&2; echo 'Error: mychart:1.0.0: not found' >&2; exit 1"})
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "exit status 1: Error: mychart:1.0.0: not found", err.Error())
+		}
+	})
+}
+
+func TestDryRunRunner(t *testing.T) {
+	t.Run("ReturnsNumberedPlanInOrderOfExecution", func(t *testing.T) {
+
+		runner := NewDryRunRunner()
+
+		// act
+		_ = runner.RunCommandWithArgs(context.Background(), "helm", []string{"lint", "mychart"})
+		_ = runner.RunCommandInDirectoryWithArgs(context.Background(), "/repo", "git", []string{"push", "origin", "main"})
+
+		assert.Equal(t, "  1. helm lint mychart\n  2. (cd /repo && git push origin main)\n", runner.Plan())
+	})
+
+	t.Run("QuotesArgumentsWithSpacesOrQuotes", func(t *testing.T) {
+
+		runner := NewDryRunRunner()
+
+		// act
+		_ = runner.RunCommandWithArgs(context.Background(), "git", []string{"commit", "-m", "it's mychart v1.0.0", ""})
+
+		assert.Equal(t, []string{`git commit -m 'it'\''s mychart v1.0.0' ''`}, runner.commands)
+	})
+
+	t.Run("DoesNotIncludeStdinInPlan", func(t *testing.T) {
+
+		runner := NewDryRunRunner()
+
+		// act
+		_ = runner.RunCommandWithArgsAndStdin(context.Background(), "helm", []string{"registry", "login", "ghcr.io", "--password-stdin"}, "s3cr3t")
+
+		assert.Equal(t, []string{"helm registry login ghcr.io --password-stdin <<< '***'"}, runner.commands)
+	})
+
+	t.Run("ReturnsNoCommandsIfNothingWasRun", func(t *testing.T) {
+
+		runner := NewDryRunRunner()
+
+		// act
+		plan := runner.Plan()
+
+		assert.Equal(t, "no commands\n", plan)
+	})
+}
+
+// withHelmRepositoryCredentials injects credentials of type helm-repository named basic-auth with a username and password and token-auth with a token
+func withHelmRepositoryCredentials(t *testing.T) {
+	dir := t.TempDir()
+
+	credentialsFile := filepath.Join(dir, "helm_repository.json")
+	credentials := `[{"name":"basic-auth","type":"helm-repository","additionalProperties":{"username":"user","password":"s3cr3t"}},{"name":"token-auth","type":"helm-repository","additionalProperties":{"token":"t0k3n"}}]`
+	if err := ioutil.WriteFile(credentialsFile, []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	originalCredentialsPath := *helmRepositoryCredentialsPath
+	*helmRepositoryCredentialsPath = credentialsFile
+	t.Cleanup(func() {
+		*helmRepositoryCredentialsPath = originalCredentialsPath
+	})
+}
+
+// withSigningCredentials injects a credential of type helm-signing-key named chart-signing and stores its keyrings in a temporary directory
+func withSigningCredentials(t *testing.T) {
+	dir := t.TempDir()
+
+	credentialsFile := filepath.Join(dir, "helm_signing_key.json")
+	credentials := `[{"name":"chart-signing","type":"helm-signing-key","additionalProperties":{"keyName":"Estafette Bot","keyring":"c2VjcmV0","publicKeyring":"cHVibGlj","passphrase":"p4ss"}}]`
+	if err := ioutil.WriteFile(credentialsFile, []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	originalCredentialsPath, originalSigningKeyringPath, originalVerificationKeyringPath := *signingCredentialsPath, signingKeyringPath, verificationKeyringPath
+	*signingCredentialsPath = credentialsFile
+	signingKeyringPath = filepath.Join(dir, "signing-keyring.gpg")
+	verificationKeyringPath = filepath.Join(dir, "verification-keyring.gpg")
+	t.Cleanup(func() {
+		*signingCredentialsPath = originalCredentialsPath
+		signingKeyringPath = originalSigningKeyringPath
+		verificationKeyringPath = originalVerificationKeyringPath
+	})
+}
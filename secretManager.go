@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// secretPlaceholderRegex matches placeholders like ${gsm:projects/my-project/secrets/db-password/versions/latest} in values
+	secretPlaceholderRegex = regexp.MustCompile(`\$\{gsm:([^}]*)\}`)
+	secretReferenceRegex   = regexp.MustCompile(`^projects/([^/]+)/secrets/([^/]+)/versions/([^/]+)$`)
+)
+
+// secretResolver returns the value of a secret by its reference, so resolving placeholders can be replaced in tests
+type secretResolver interface {
+	ResolveSecret(ctx context.Context, reference string) (string, error)
+}
+
+// gcloudSecretResolver accesses google secret manager secrets with gcloud, authenticated as the service account of the credential by initCredential
+type gcloudSecretResolver struct {
+	runner CommandRunner
+}
+
+func (r gcloudSecretResolver) ResolveSecret(ctx context.Context, reference string) (string, error) {
+	matches := secretReferenceRegex.FindStringSubmatch(reference)
+	if matches == nil {
+		return "", fmt.Errorf("secret reference %v is not valid; use projects/<project>/secrets/<secret>/versions/<version>", reference)
+	}
+	return r.runner.GetCommandWithArgsOutput(ctx, "gcloud", []string{"secrets", "versions", "access", matches[3], "--secret", matches[2], "--project", matches[1]})
+}
+
+// hasSecretPlaceholders returns true if any of the values contains a secret manager placeholder
+func hasSecretPlaceholders(value interface{}) bool {
+	for _, leaf := range leafStrings(value) {
+		if secretPlaceholderRegex.MatchString(leaf) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSecretPlaceholders replaces the secret manager placeholders in the string values with the secrets they refer to; every secret is resolved once and masked wherever it shows up
+func resolveSecretPlaceholders(ctx context.Context, resolver secretResolver, value interface{}) (interface{}, error) {
+	return resolvePlaceholders(ctx, resolver, value, map[string]string{})
+}
+
+func resolvePlaceholders(ctx context.Context, resolver secretResolver, value interface{}, resolved map[string]string) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			r, err := resolvePlaceholders(ctx, resolver, item, resolved)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = r
+		}
+		return m, nil
+
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := resolvePlaceholders(ctx, resolver, item, resolved)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = r
+		}
+		return l, nil
+
+	case string:
+		var err error
+		s := secretPlaceholderRegex.ReplaceAllStringFunc(v, func(placeholder string) string {
+			reference := secretPlaceholderRegex.FindStringSubmatch(placeholder)[1]
+			if secret, ok := resolved[reference]; ok {
+				return secret
+			}
+			if err != nil {
+				return placeholder
+			}
+			log.Info().Msgf("Resolving secret %v...", reference)
+			var secret string
+			secret, err = resolver.ResolveSecret(ctx, reference)
+			if err != nil {
+				err = fmt.Errorf("failed resolving secret %v: %w", reference, err)
+				return placeholder
+			}
+			secretMasker.AddSecrets(secret)
+			resolved[reference] = secret
+			return secret
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+
+	default:
+		return value, nil
+	}
+}
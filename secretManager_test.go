@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSecretResolver returns secrets from a map and counts how often each one is resolved
+type fakeSecretResolver struct {
+	secrets map[string]string
+	calls   map[string]int
+}
+
+func (r *fakeSecretResolver) ResolveSecret(ctx context.Context, reference string) (string, error) {
+	if r.calls == nil {
+		r.calls = map[string]int{}
+	}
+	r.calls[reference]++
+	secret, ok := r.secrets[reference]
+	if !ok {
+		return "", fmt.Errorf("secret %v not found", reference)
+	}
+	return secret, nil
+}
+
+func TestResolveSecretPlaceholders(t *testing.T) {
+	t.Run("ReplacesPlaceholdersInNestedValuesResolvingEverySecretOnce", func(t *testing.T) {
+
+		withSecretMasker(t)
+		resolver := &fakeSecretResolver{secrets: map[string]string{"projects/x/secrets/db-password/versions/latest": "hunter22"}}
+		values := map[string]interface{}{
+			"database": map[string]interface{}{
+				"password": "${gsm:projects/x/secrets/db-password/versions/latest}",
+				"url":      "postgres://app:${gsm:projects/x/secrets/db-password/versions/latest}@db/app",
+			},
+			"hosts":    []interface{}{"a.example.com"},
+			"replicas": 3,
+		}
+
+		// act
+		resolved, err := resolveSecretPlaceholders(context.Background(), resolver, values)
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"database": map[string]interface{}{
+				"password": "hunter22",
+				"url":      "postgres://app:hunter22@db/app",
+			},
+			"hosts":    []interface{}{"a.example.com"},
+			"replicas": 3,
+		}, resolved)
+		assert.Equal(t, 1, resolver.calls["projects/x/secrets/db-password/versions/latest"])
+		assert.Equal(t, "password ***", secretMasker.MaskString("password hunter22"))
+	})
+
+	t.Run("ReturnsErrorIfSecretCannotBeResolved", func(t *testing.T) {
+
+		resolver := &fakeSecretResolver{}
+
+		// act
+		_, err := resolveSecretPlaceholders(context.Background(), resolver, map[string]interface{}{"password": "${gsm:projects/x/secrets/missing/versions/1}"})
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "failed resolving secret projects/x/secrets/missing/versions/1: secret projects/x/secrets/missing/versions/1 not found", err.Error())
+		}
+	})
+}
+
+func TestGcloudSecretResolver(t *testing.T) {
+	t.Run("AccessesSecretVersionWithGcloud", func(t *testing.T) {
+
+		runner := &fakeCommandRunner{outputs: map[string]string{"gcloud secrets versions access": "hunter22"}}
+
+		// act
+		secret, err := gcloudSecretResolver{runner: runner}.ResolveSecret(context.Background(), "projects/my-project/secrets/db-password/versions/latest")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "hunter22", secret)
+		assert.Equal(t, []string{"gcloud secrets versions access latest --secret db-password --project my-project"}, runner.commandLines())
+	})
+
+	t.Run("ReturnsErrorForInvalidReference", func(t *testing.T) {
+
+		runner := &fakeCommandRunner{}
+
+		// act
+		_, err := gcloudSecretResolver{runner: runner}.ResolveSecret(context.Background(), "db-password")
+
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, len(runner.commands))
+	})
+}
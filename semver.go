@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semanticVersion is a parsed semantic version as used for chart versions
+type semanticVersion struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+	Build      string
+}
+
+// parseSemanticVersion parses a version like 1.2.3-beta.1+build.5
+func parseSemanticVersion(version string) (v semanticVersion, err error) {
+	matches := semverRegex.FindStringSubmatch(version)
+	if matches == nil {
+		return v, fmt.Errorf("'%v' is not a valid semantic version", version)
+	}
+	v.Major, _ = strconv.Atoi(matches[1])
+	v.Minor, _ = strconv.Atoi(matches[2])
+	v.Patch, _ = strconv.Atoi(matches[3])
+	v.Prerelease = matches[4]
+	v.Build = matches[5]
+	return v, nil
+}
+
+// IsPrerelease returns true for versions with a label like 1.2.3-beta
+func (v semanticVersion) IsPrerelease() bool {
+	return v.Prerelease != ""
+}
+
+// Compare returns -1, 0 or 1 if v has lower, equal or higher precedence than other, ignoring build metadata
+func (v semanticVersion) Compare(other semanticVersion) int {
+	for _, d := range []int{v.Major - other.Major, v.Minor - other.Minor, v.Patch - other.Patch} {
+		if d != 0 {
+			return sign(d)
+		}
+	}
+
+	// a version without prerelease label has higher precedence
+	switch {
+	case v.Prerelease == other.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case other.Prerelease == "":
+		return -1
+	}
+
+	identifiers, otherIdentifiers := strings.Split(v.Prerelease, "."), strings.Split(other.Prerelease, ".")
+	for i := 0; i < len(identifiers) && i < len(otherIdentifiers); i++ {
+		a, b := identifiers[i], otherIdentifiers[i]
+		if a == b {
+			continue
+		}
+		aNumber, aErr := strconv.Atoi(a)
+		bNumber, bErr := strconv.Atoi(b)
+		switch {
+		case aErr == nil && bErr == nil:
+			return sign(aNumber - bNumber)
+		case aErr == nil:
+			// numeric identifiers have lower precedence than alphanumeric ones
+			return -1
+		case bErr == nil:
+			return 1
+		}
+		return strings.Compare(a, b)
+	}
+	return sign(len(identifiers) - len(otherIdentifiers))
+}
+
+// compareVersions compares two version strings by semantic version precedence; invalid versions have lower precedence than valid ones and are compared as strings
+func compareVersions(a, b string) int {
+	va, errA := parseSemanticVersion(a)
+	vb, errB := parseSemanticVersion(b)
+	switch {
+	case errA == nil && errB == nil:
+		return va.Compare(vb)
+	case errA == nil:
+		return 1
+	case errB == nil:
+		return -1
+	}
+	return strings.Compare(a, b)
+}
+
+func sign(i int) int {
+	switch {
+	case i < 0:
+		return -1
+	case i > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompareVersions(t *testing.T) {
+	t.Run("SortsBySemanticVersionPrecedence", func(t *testing.T) {
+
+		versions := []string{"1.0.0", "1.0.0-rc.1", "1.0.0-alpha.beta", "0.9.0", "1.0.0-beta.11", "1.0.0-alpha", "1.0.0-beta.2", "1.0.0-beta", "1.0.0-alpha.1", "1.10.0", "1.2.0", "latest"}
+
+		// act
+		sort.Slice(versions, func(i, j int) bool {
+			return compareVersions(versions[i], versions[j]) < 0
+		})
+
+		assert.Equal(t, []string{"latest", "0.9.0", "1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta", "1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.2.0", "1.10.0"}, versions)
+	})
+
+	t.Run("IgnoresBuildMetadata", func(t *testing.T) {
+
+		// act
+		result := compareVersions("1.0.0+build.1", "1.0.0+build.2")
+
+		assert.Equal(t, 0, result)
+	})
+}
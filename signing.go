@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// signingKeyringPath is where the secret keyring of the selected signing credential is stored for helm package --sign
+	signingKeyringPath = "/signing-keyring.gpg"
+	// verificationKeyringPath is where the public keyring of the selected signing credential is stored for verifying charts
+	verificationKeyringPath = "/verification-keyring.gpg"
+)
+
+// signingKey contains what helm needs to sign or verify a chart after the keyrings are stored on disk
+type signingKey struct {
+	KeyName             string
+	KeyringPath         string
+	VerificationKeyring string
+	Passphrase          string
+}
+
+// initSigningKey reads the credential of type helm-signing-key set by signingCredentials and stores its keyrings on disk
+func initSigningKey(params params) (*signingKey, error) {
+
+	if !foundation.FileExists(*signingCredentialsPath) {
+		return nil, fmt.Errorf("credentials of type helm-signing-key are not injected; configure this extension as trusted and inject credentials of type helm-signing-key")
+	}
+
+	log.Info().Msgf("Reading credentials from file at path %v...", *signingCredentialsPath)
+	var credentials []HelmSigningKeyCredentials
+	err := readCredentialsFile(*signingCredentialsPath, &credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Info().Msgf("Checking if credential %v exists...", params.SigningCredentials)
+	credential := GetHelmSigningKeyCredentialsByName(credentials, params.SigningCredentials)
+	if credential == nil {
+		return nil, fmt.Errorf("credential with name %v does not exist", params.SigningCredentials)
+	}
+
+	properties := credential.AdditionalProperties
+	keyring, err := base64.StdEncoding.DecodeString(properties.Keyring)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding keyring of credential %v; it has to be base64 encoded: %w", params.SigningCredentials, err)
+	}
+	publicKeyring := keyring
+	if properties.PublicKeyring != "" {
+		publicKeyring, err = base64.StdEncoding.DecodeString(properties.PublicKeyring)
+		if err != nil {
+			return nil, fmt.Errorf("failed decoding public keyring of credential %v; it has to be base64 encoded: %w", params.SigningCredentials, err)
+		}
+	}
+	if len(publicKeyring) == 0 {
+		return nil, fmt.Errorf("credential %v has no keyring", params.SigningCredentials)
+	}
+
+	if params.DryRun {
+		log.Info().Msgf("Dry run: skipping storing keyrings of credential %v on disk...", params.SigningCredentials)
+	} else {
+		log.Info().Msgf("Storing keyrings of credential %v on disk...", params.SigningCredentials)
+		if len(keyring) > 0 {
+			err = ioutil.WriteFile(signingKeyringPath, keyring, 0600)
+			if err != nil {
+				return nil, fmt.Errorf("failed writing signing keyring: %w", err)
+			}
+		}
+		err = ioutil.WriteFile(verificationKeyringPath, publicKeyring, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed writing verification keyring: %w", err)
+		}
+	}
+
+	// the passphrase is passed on stdin, and masked if a command ever prints it
+	secretMasker.AddSecrets(properties.Passphrase)
+
+	return &signingKey{
+		KeyName:             properties.KeyName,
+		KeyringPath:         signingKeyringPath,
+		VerificationKeyring: verificationKeyringPath,
+		Passphrase:          properties.Passphrase,
+	}, nil
+}
+
+// verifyChart checks the provenance file next to a packaged chart with the public keyring of the signing credential
+func verifyChart(ctx context.Context, runner CommandRunner, key *signingKey, filename string) error {
+	log.Info().Msgf("Verifying provenance of chart %v...", filename)
+	err := runner.RunCommandWithArgs(ctx, "helm", []string{"verify", filename, "--keyring", key.VerificationKeyring})
+	if err != nil {
+		return fmt.Errorf("failed verifying provenance of chart %v: %w", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+// isSopsEncrypted returns true if the values of a file have the metadata sops adds when encrypting it
+func isSopsEncrypted(values map[string]interface{}) bool {
+	metadata, ok := values["sops"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	_, hasMac := metadata["mac"]
+	return hasMac
+}
+
+// decryptSopsValues decrypts a sops encrypted values file with its age or gcp kms keys; the decrypted values are only kept in memory and registered as secrets, so they never end up in the workspace or build log
+func decryptSopsValues(ctx context.Context, runner CommandRunner, params params, file string, encrypted map[string]interface{}) (map[string]interface{}, error) {
+	metadata, _ := encrypted["sops"].(map[string]interface{})
+	ageKeys, _ := metadata["age"].([]interface{})
+	kmsKeys, _ := metadata["gcp_kms"].([]interface{})
+
+	hasAgeKey := params.SopsAgeKey != "" || os.Getenv("SOPS_AGE_KEY") != "" || os.Getenv("SOPS_AGE_KEY_FILE") != ""
+	if params.SopsAgeKey != "" {
+		secretMasker.AddSecrets(params.SopsAgeKey)
+		os.Setenv("SOPS_AGE_KEY", params.SopsAgeKey)
+	}
+	if len(kmsKeys) > 0 && foundation.FileExists(serviceAccountKeyfilePath) {
+		// sops authenticates to gcp kms with the service account keyfile stored by initCredential
+		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", serviceAccountKeyfilePath)
+	}
+
+	// files with other kinds of keys like pgp are left to sops to find a key for
+	otherKeys := 0
+	for _, kind := range []string{"pgp", "kms", "azure_kv", "hc_vault"} {
+		keys, _ := metadata[kind].([]interface{})
+		otherKeys += len(keys)
+	}
+	if otherKeys == 0 && !(len(ageKeys) > 0 && hasAgeKey) && !(len(kmsKeys) > 0 && initializedCredential != "") {
+		switch {
+		case len(ageKeys) > 0 && len(kmsKeys) > 0:
+			return nil, fmt.Errorf("values file %v is encrypted with sops; set sopsAgeKey to decrypt it with age, or credentials to decrypt it with gcp kms in the diff and install actions", file)
+		case len(ageKeys) > 0:
+			return nil, fmt.Errorf("values file %v is encrypted with sops using age; set sopsAgeKey to decrypt it", file)
+		case len(kmsKeys) > 0:
+			// only diff and install authenticate with the credential, test never does even when credentials is set or defaulted from the release target
+			return nil, fmt.Errorf("values file %v is encrypted with sops using gcp kms, which is only supported in the diff and install actions; set credentials to decrypt it with their service account", file)
+		}
+	}
+
+	log.Info().Msgf("Decrypting sops encrypted values file %v...", file)
+	output, err := runner.GetCommandWithArgsOutput(ctx, "sops", []string{"--decrypt", "--input-type", "yaml", "--output-type", "yaml", file})
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypting values file %v with sops: %w", file, err)
+	}
+
+	output, err = expandValuesTemplate(params, output, "decrypted values file "+file)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := unmarshalValues([]byte(output), "decrypted values file "+file)
+	if err != nil {
+		return nil, err
+	}
+
+	// the values that were encrypted are secrets, so they're masked wherever they show up
+	delete(encrypted, "sops")
+	secretMasker.AddSecrets(encryptedLeaves(encrypted, decrypted)...)
+
+	return decrypted, nil
+}
+
+// encryptedLeaves returns the decrypted values of the leaves that sops encrypted, leaving out the ones excluded from encryption by its encrypted_regex or unencrypted_suffix
+func encryptedLeaves(encrypted, decrypted interface{}) (leaves []string) {
+	switch e := encrypted.(type) {
+	case map[string]interface{}:
+		d, _ := decrypted.(map[string]interface{})
+		for key, item := range e {
+			leaves = append(leaves, encryptedLeaves(item, d[key])...)
+		}
+	case []interface{}:
+		d, _ := decrypted.([]interface{})
+		for i, item := range e {
+			if i < len(d) {
+				leaves = append(leaves, encryptedLeaves(item, d[i])...)
+			}
+		}
+	case string:
+		if strings.HasPrefix(e, "ENC[") {
+			leaves = append(leaves, secretLeaves(decrypted)...)
+		}
+	}
+	return
+}
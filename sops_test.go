@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sopsEncryptedValues = `image:
+  tag: 1.0.0
+database:
+  password: ENC[AES256_GCM,data:Tr7o1A==,iv:abc=,tag:def=,type:str]
+sops:
+  age:
+  - recipient: age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p
+    enc: |
+      -----BEGIN AGE ENCRYPTED FILE-----
+      -----END AGE ENCRYPTED FILE-----
+  lastmodified: "2023-06-30T12:00:00Z"
+  mac: ENC[AES256_GCM,data:mac=,iv:abc=,tag:def=,type:str]
+  version: 3.7.3
+`
+
+// withSecretMasker replaces the secretMasker with a new one for the duration of the test, so the secrets it learns don't leak into other tests
+func withSecretMasker(t *testing.T) {
+	original := secretMasker
+	secretMasker = newOutputMasker(defaultSecretKeyPatterns...)
+	t.Cleanup(func() {
+		secretMasker = original
+	})
+}
+
+func TestReadValuesFile(t *testing.T) {
+	t.Run("DecryptsSopsEncryptedFileInMemoryAndMasksEncryptedValues", func(t *testing.T) {
+
+		inTempDir(t)
+		withSecretMasker(t)
+		t.Setenv("SOPS_AGE_KEY", "")
+		_ = ioutil.WriteFile("secrets.yaml", []byte(sopsEncryptedValues), 0644)
+		runner := &fakeCommandRunner{outputs: map[string]string{"sops --decrypt": "image:\n  tag: 1.0.0\ndatabase:\n  password: hunter22\n"}}
+
+		// act
+		values, err := readValuesFile(context.Background(), runner, params{SopsAgeKey: "AGE-SECRET-KEY-1ABC"}, "secrets.yaml")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"sops --decrypt --input-type yaml --output-type yaml secrets.yaml"}, runner.commandLines())
+		assert.Equal(t, map[string]interface{}{"image": map[string]interface{}{"tag": "1.0.0"}, "database": map[string]interface{}{"password": "hunter22"}}, values)
+		assert.Equal(t, "AGE-SECRET-KEY-1ABC", os.Getenv("SOPS_AGE_KEY"))
+		assert.Equal(t, "tag 1.0.0 password *** key ***", secretMasker.MaskString("tag 1.0.0 password hunter22 key AGE-SECRET-KEY-1ABC"))
+	})
+
+	t.Run("ReturnsErrorIfThereIsNoKeyToDecryptWith", func(t *testing.T) {
+
+		inTempDir(t)
+		withSecretMasker(t)
+		t.Setenv("SOPS_AGE_KEY", "")
+		t.Setenv("SOPS_AGE_KEY_FILE", "")
+		_ = ioutil.WriteFile("secrets.yaml", []byte(sopsEncryptedValues), 0644)
+		runner := &fakeCommandRunner{}
+
+		// act
+		_, err := readValuesFile(context.Background(), runner, params{}, "secrets.yaml")
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "values file secrets.yaml is encrypted with sops using age; set sopsAgeKey to decrypt it", err.Error())
+		}
+		assert.Equal(t, 0, len(runner.commands))
+	})
+
+	t.Run("ReturnsErrorIfFileIsEncryptedWithGcpKmsWithoutCredentials", func(t *testing.T) {
+
+		inTempDir(t)
+		withSecretMasker(t)
+		_ = ioutil.WriteFile("secrets.yaml", []byte(`database:
+  password: ENC[AES256_GCM,data:Tr7o1A==,iv:abc=,tag:def=,type:str]
+sops:
+  gcp_kms:
+  - resource_id: projects/my-project/locations/global/keyRings/sops/cryptoKeys/sops-key
+    enc: CiQAbc==
+  mac: ENC[AES256_GCM,data:mac=,iv:abc=,tag:def=,type:str]
+`), 0644)
+		runner := &fakeCommandRunner{}
+
+		// act
+		_, err := readValuesFile(context.Background(), runner, params{}, "secrets.yaml")
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "values file secrets.yaml is encrypted with sops using gcp kms, which is only supported in the diff and install actions; set credentials to decrypt it with their service account", err.Error())
+		}
+		assert.Equal(t, 0, len(runner.commands))
+	})
+
+	t.Run("ReturnsErrorIfFileIsEncryptedWithGcpKmsAndCredentialsAreNotInitialized", func(t *testing.T) {
+
+		inTempDir(t)
+		withSecretMasker(t)
+		_ = ioutil.WriteFile("secrets.yaml", []byte(`database:
+  password: ENC[AES256_GCM,data:Tr7o1A==,iv:abc=,tag:def=,type:str]
+sops:
+  gcp_kms:
+  - resource_id: projects/my-project/locations/global/keyRings/sops/cryptoKeys/sops-key
+    enc: CiQAbc==
+  mac: ENC[AES256_GCM,data:mac=,iv:abc=,tag:def=,type:str]
+`), 0644)
+		runner := &fakeCommandRunner{}
+
+		// act
+		_, err := readValuesFile(context.Background(), runner, params{Credentials: "gke-production"}, "secrets.yaml")
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "values file secrets.yaml is encrypted with sops using gcp kms, which is only supported in the diff and install actions; set credentials to decrypt it with their service account", err.Error())
+		}
+		assert.Equal(t, 0, len(runner.commands))
+	})
+
+	t.Run("DecryptsFileEncryptedWithGcpKmsOnceCredentialIsInitialized", func(t *testing.T) {
+
+		inTempDir(t)
+		withSecretMasker(t)
+		withCredentials(t)
+		initializedCredential = "gke-production"
+		_ = ioutil.WriteFile("secrets.yaml", []byte(`database:
+  password: ENC[AES256_GCM,data:Tr7o1A==,iv:abc=,tag:def=,type:str]
+sops:
+  gcp_kms:
+  - resource_id: projects/my-project/locations/global/keyRings/sops/cryptoKeys/sops-key
+    enc: CiQAbc==
+  mac: ENC[AES256_GCM,data:mac=,iv:abc=,tag:def=,type:str]
+`), 0644)
+		runner := &fakeCommandRunner{outputs: map[string]string{"sops --decrypt": "database:\n  password: s3cr3t\n"}}
+
+		// act
+		values, err := readValuesFile(context.Background(), runner, params{Credentials: "gke-production"}, "secrets.yaml")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"sops --decrypt --input-type yaml --output-type yaml secrets.yaml"}, runner.commandLines())
+		assert.Equal(t, map[string]interface{}{"database": map[string]interface{}{"password": "s3cr3t"}}, values)
+	})
+
+	t.Run("ReadsFileWithoutSopsMetadataAsIs", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = ioutil.WriteFile("values.yaml", []byte("sops: enabled\n"), 0644)
+		runner := &fakeCommandRunner{}
+
+		// act
+		values, err := readValuesFile(context.Background(), runner, params{}, "values.yaml")
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"sops": "enabled"}, values)
+		assert.Equal(t, 0, len(runner.commands))
+	})
+}
+
+func TestEncryptedLeaves(t *testing.T) {
+	t.Run("ReturnsDecryptedValuesOfEncryptedLeavesOnly", func(t *testing.T) {
+
+		encrypted := map[string]interface{}{"replicas_unencrypted": 3, "hosts": []interface{}{"ENC[AES256_GCM,data:a]", "ENC[AES256_GCM,data:b]"}, "apiKey": "ENC[AES256_GCM,data:c]"}
+		decrypted := map[string]interface{}{"replicas_unencrypted": 3, "hosts": []interface{}{"a.example.com", "b.example.com"}, "apiKey": "abcdef"}
+
+		// act
+		leaves := encryptedLeaves(encrypted, decrypted)
+
+		assert.ElementsMatch(t, []string{"a.example.com", "b.example.com", "abcdef"}, leaves)
+	})
+}
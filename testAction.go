@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+)
+
+type testAction struct{}
+
+func (testAction) Name() string {
+	return "test"
+}
+
+func (testAction) Description() string {
+	return "Installs the chart in a kind service container to test it"
+}
+
+func (testAction) RequiredParams() []string {
+	return []string{"chart", "version", "kindHost", "timeout"}
+}
+
+func (testAction) OptionalParams() []string {
+	return []string{"appVersion", "helmSubdir", "labelSelector", "repoUrl", "sopsAgeKey", "targetValuesFile", "templateValues", "values", "valuesFile", "valuesSources"}
+}
+
+func (testAction) Run(ctx context.Context, runner CommandRunner, params params) error {
+	log.Info().Msgf("Testing chart %v with app version %v and version %v on kind host %v...", params.Chart, params.AppVersion, params.Version, params.KindHost)
+
+	if params.DryRun {
+		log.Info().Msgf("Dry run: skipping waiting for kind host %v and writing its config to ~/.kube/config", params.KindHost)
+	} else {
+		err := initKindKubeConfig(params)
+		if err != nil {
+			return err
+		}
+	}
+
+	mergedValues, err := prepareValues(ctx, runner, params)
+	if err != nil {
+		return err
+	}
+
+	filename := fmt.Sprintf("%v-%v.tgz", params.Chart, params.Version)
+	if !foundation.FileExists(filename) {
+		log.Info().Msgf("No helm package present, retrieving helm chart %v version %v from %v...", params.Chart, params.Version, params.RepositoryURL)
+		err = runner.RunCommandWithArgs(ctx, "helm", []string{"fetch", params.Chart, "--version", params.Version, "--repo", params.RepositoryURL})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Info().Msg("Showing template to be installed...")
+	diffArgs := newHelmArgs("diff", "upgrade", params.Chart, filename).
+		ValuesStdin(mergedValues).
+		BoolFlag("--allow-unreleased", true)
+	err = runHelmWithValues(ctx, runner, diffArgs.Args(), mergedValues)
+	if err != nil {
+		return err
+	}
+
+	labelSelector := params.LabelSelector()
+
+	log.Printf("\nInstalling chart file %v and waiting for %v for it to be ready...\n", filename, params.Timeout)
+	upgradeArgs := newHelmArgs("upgrade", "--install", params.Chart, filename).
+		ValuesStdin(mergedValues).
+		Flag("--history-max", "1").
+		Flag("--timeout", params.Timeout)
+	err = runHelmWithValues(ctx, runner, upgradeArgs.Args(), mergedValues)
+	if err != nil {
+		log.Printf("Installation failed, showing logs...")
+		_ = runner.RunCommandWithArgs(ctx, "kubectl", []string{"get", "all,secret"})
+		_ = runner.RunCommandWithArgs(ctx, "kubectl", []string{"logs", "-l", labelSelector, "--all-containers=true"})
+
+		log.Info().Msg("Showing all resources...")
+		_ = runner.RunCommandWithArgs(ctx, "kubectl", []string{"get", "all,secret"})
+		return fmt.Errorf("installing chart %v failed: %w", params.Chart, err)
+	}
+
+	log.Info().Msg("Showing logs for container...")
+	_ = runner.RunCommandWithArgs(ctx, "kubectl", []string{"logs", "-l", labelSelector, "--all-containers=true"})
+
+	log.Info().Msg("Showing all resources...")
+	_ = runner.RunCommandWithArgs(ctx, "kubectl", []string{"get", "all,secret"})
+
+	return nil
+}
+
+// initKindKubeConfig waits for the kind host to be ready and writes its config to ~/.kube/config with the server pointing to the kind host
+func initKindKubeConfig(params params) error {
+	log.Info().Msg("Waiting for kind host to be ready...")
+	httpClient := &http.Client{
+		Timeout: time.Second * 1,
+	}
+
+	for true {
+		_, err := httpClient.Get(fmt.Sprintf("http://%v:10080/kubernetes-ready", params.KindHost))
+		if err == nil {
+			break
+		} else {
+			time.Sleep(1 * time.Second)
+		}
+	}
+
+	log.Info().Msg("Preparing kind host for using Helm...")
+	response, err := httpClient.Get(fmt.Sprintf("http://%v:10080/config", params.KindHost))
+	if err != nil {
+		return fmt.Errorf("failed to retrieve kind config from http://%v:10080/config: %w", params.KindHost, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to retrieve kind config from http://%v:10080/config; status code %v", params.KindHost, response.StatusCode)
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve kind config from http://%v:10080/config: %w", params.KindHost, err)
+	}
+
+	serverRegex, err := regexp.Compile(`server:\s+(http|https)://([^:]+):(\d+)`)
+	if err != nil {
+		return fmt.Errorf("failed isolating server in config from http://%v:10080/config: %w", params.KindHost, err)
+	}
+
+	serverMatches := serverRegex.FindStringSubmatch(string(body))
+	if len(serverMatches) != 4 {
+		return fmt.Errorf("failed isolating server in config from http://%v:10080/config; matches: %v", params.KindHost, serverMatches)
+	}
+
+	kubeConfig := string(body)
+
+	kubeConfig = strings.ReplaceAll(kubeConfig, serverMatches[2], params.KindHost)
+	kubeConfig = strings.ReplaceAll(kubeConfig, "localhost", params.KindHost)
+
+	usr, _ := user.Current()
+	homeDir := usr.HomeDir
+	err = ioutil.WriteFile(filepath.Join(homeDir, ".kube/config"), []byte(kubeConfig), 0600)
+	if err != nil {
+		return fmt.Errorf("failed writing ~/.kube/config: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+)
+
+type uninstallAction struct{}
+
+func (uninstallAction) Name() string {
+	return "uninstall"
+}
+
+func (uninstallAction) Description() string {
+	return "Uninstalls the release from a kubernetes cluster"
+}
+
+func (uninstallAction) RequiredParams() []string {
+	return []string{"credentials", "namespace", "release", "timeout"}
+}
+
+func (uninstallAction) OptionalParams() []string {
+	return []string{"chart"}
+}
+
+func (uninstallAction) Run(ctx context.Context, runner CommandRunner, params params) error {
+	log.Info().Msgf("Uninstalling chart %v...", params.Chart)
+
+	err := initKubectl(ctx, runner, params)
+	if err != nil {
+		return err
+	}
+
+	uninstallArgs := newHelmArgs("uninstall", params.ReleaseName).
+		Flag("--namespace", params.Namespace).
+		Flag("--timeout", params.Timeout)
+	return runner.RunCommandWithArgs(ctx, "helm", uninstallArgs.Args())
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	semverRegex      = regexp.MustCompile(`^(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-((?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*)(?:\.(?:0|[1-9]\d*|\d*[a-zA-Z-][0-9a-zA-Z-]*))*))?(?:\+([0-9a-zA-Z-]+(?:\.[0-9a-zA-Z-]+)*))?$`)
+	dns1123Regex     = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+	releaseNameRegex = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+	bucketRegex      = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{1,61}[a-z0-9]$`)
+)
+
+// validationProblem describes a single issue with a parameter
+type validationProblem struct {
+	Parameter string
+	Message   string
+}
+
+func (vp validationProblem) String() string {
+	if vp.Parameter == "" {
+		return vp.Message
+	}
+	return fmt.Sprintf("%v: %v", vp.Parameter, vp.Message)
+}
+
+// validationReport collects all problems found while validating the parameters, so they can be reported at once
+type validationReport struct {
+	Errors   []validationProblem
+	Warnings []validationProblem
+}
+
+func (r *validationReport) addError(parameter, format string, a ...interface{}) {
+	r.Errors = append(r.Errors, validationProblem{Parameter: parameter, Message: fmt.Sprintf(format, a...)})
+}
+
+func (r *validationReport) addWarning(parameter, format string, a ...interface{}) {
+	r.Warnings = append(r.Warnings, validationProblem{Parameter: parameter, Message: fmt.Sprintf(format, a...)})
+}
+
+// HasErrors returns true if any of the problems prevents the action from running
+func (r validationReport) HasErrors() bool {
+	return len(r.Errors) > 0
+}
+
+// validateParams checks the raw parameters yaml for unknown keys and the parameters with defaults applied for the selected action
+func validateParams(paramsYAML string, p params) (report validationReport) {
+
+	var rawParams map[string]interface{}
+	if err := yaml.Unmarshal([]byte(paramsYAML), &rawParams); err != nil {
+		report.addError("", "failed unmarshalling parameters: %v", err)
+		return
+	}
+
+	rawKeys := []string{}
+	for key := range rawParams {
+		rawKeys = append(rawKeys, key)
+	}
+	sort.Strings(rawKeys)
+
+	knownKeys := knownParamKeys()
+	setKeys := []string{}
+	for _, key := range rawKeys {
+		if !foundation.StringArrayContains(knownKeys, key) {
+			if suggestion := suggestParamKey(key, knownKeys); suggestion != "" {
+				report.addError(key, "unknown parameter; did you mean '%v'?", suggestion)
+			} else {
+				report.addError(key, "unknown parameter")
+			}
+			continue
+		}
+		setKeys = append(setKeys, key)
+	}
+
+	actionKey := "action"
+	if len(p.Actions) > 0 {
+		actionKey = "actions"
+		if p.Action != "" {
+			report.addError("action", "parameter cannot be combined with actions; add the action to the actions list instead")
+		}
+	}
+
+	steps := p.Steps()
+	if len(steps) == 0 {
+		report.addError(actionKey, "no action set; please use %v", actions.SupportedList())
+		return
+	}
+
+	usedKeys := append([]string{}, globalParamKeys...)
+	missingKeys := []string{}
+	missingFor := map[string][]string{}
+	for _, step := range steps {
+		a, ok := actions.Get(step)
+		if !ok {
+			report.addError(actionKey, "action '%v' is not supported; please use %v", step, actions.SupportedList())
+			continue
+		}
+		for _, key := range a.RequiredParams() {
+			if paramValue(p, key) == "" {
+				if _, exists := missingFor[key]; !exists {
+					missingKeys = append(missingKeys, key)
+				}
+				missingFor[key] = append(missingFor[key], step)
+			}
+		}
+		for _, key := range append(a.RequiredParams(), a.OptionalParams()...) {
+			usedKeys = append(usedKeys, key)
+		}
+	}
+	if report.HasErrors() {
+		return
+	}
+
+	for _, key := range missingKeys {
+		report.addError(key, "parameter is required for %v", describeActions(missingFor[key]))
+	}
+
+	for _, key := range setKeys {
+		if !foundation.StringArrayContains(usedKeys, key) {
+			report.addWarning(key, "parameter is ignored by %v", describeActions(steps))
+		}
+	}
+
+	uses := func(key string) bool {
+		return foundation.StringArrayContains(usedKeys, key) && paramValue(p, key) != ""
+	}
+
+	if uses("version") && !semverRegex.MatchString(p.Version) {
+		report.addError("version", "'%v' is not a valid semantic version", p.Version)
+	}
+
+	if uses("timeout") {
+		if d, err := time.ParseDuration(p.Timeout); err != nil {
+			report.addError("timeout", "'%v' is not a valid duration; use a value with units like 300s or 5m", p.Timeout)
+		} else if d <= 0 {
+			report.addError("timeout", "'%v' has to be larger than 0", p.Timeout)
+		}
+	}
+
+	if uses("namespace") && (len(p.Namespace) > 63 || !dns1123Regex.MatchString(p.Namespace)) {
+		report.addError("namespace", "'%v' is not a valid namespace; it has to consist of at most 63 lower case alphanumeric characters or '-' and start and end with an alphanumeric character", p.Namespace)
+	}
+
+	if uses("release") && (len(p.ReleaseName) > 53 || !releaseNameRegex.MatchString(p.ReleaseName)) {
+		report.addError("release", "'%v' is not a valid release name; it has to consist of at most 53 lower case alphanumeric characters, '-' or '.' and start and end with an alphanumeric character", p.ReleaseName)
+	}
+
+	if uses("bucket") {
+		if strings.HasPrefix(p.Bucket, "gs://") {
+			report.addError("bucket", "'%v' should be the bucket name only; did you mean '%v'?", p.Bucket, strings.TrimPrefix(p.Bucket, "gs://"))
+		} else if !bucketRegex.MatchString(p.Bucket) {
+			report.addError("bucket", "'%v' is not a valid bucket name", p.Bucket)
+		}
+	}
+
+	if uses("repoPushAttempts") && p.RepositoryPushAttempts < 1 {
+		report.addError("repoPushAttempts", "'%v' has to be at least 1", p.RepositoryPushAttempts)
+	}
+
+	if uses("purgeKeepReleases") && p.PurgeKeepReleases < 0 {
+		report.addError("purgeKeepReleases", "'%v' cannot be negative", p.PurgeKeepReleases)
+	}
+
+	if uses("purgePrereleaseDays") && p.PurgePrereleaseDays < 0 {
+		report.addError("purgePrereleaseDays", "'%v' cannot be negative", p.PurgePrereleaseDays)
+	}
+
+	if uses("purgePinned") {
+		for i, pattern := range p.PurgePinned {
+			if _, err := path.Match(pattern, ""); err != nil {
+				report.addError("purgePinned", "item %v '%v' is not a valid glob pattern", i, pattern)
+			}
+		}
+	}
+
+	if uses("verify") && p.SigningCredentials == "" {
+		report.addError("verify", "parameter requires signingCredentials to be set to the credential with the keyring to verify the chart with")
+	}
+
+	if uses("registry") && !strings.HasPrefix(p.Registry, "oci://") {
+		report.addError("registry", "'%v' is not an oci registry url; did you mean 'oci://%v'?", p.Registry, strings.TrimPrefix(strings.TrimPrefix(p.Registry, "https://"), "http://"))
+	}
+
+	if uses("cosign") && p.Registry == "" {
+		report.addError("cosign", "parameter requires registry to be set, since only charts published to an oci registry can be signed with cosign")
+	}
+
+	if uses("chartMuseumUrl") {
+		if u, err := url.Parse(p.ChartMuseumURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			report.addError("chartMuseumUrl", "'%v' is not a valid http(s) url", p.ChartMuseumURL)
+		}
+	}
+
+	if uses("chartMuseumOnConflict") && !foundation.StringArrayContains(chartMuseumOnConflictPolicies, p.ChartMuseumOnConflict) {
+		report.addError("chartMuseumOnConflict", "'%v' is not supported; please use %v", p.ChartMuseumOnConflict, strings.Join(chartMuseumOnConflictPolicies, ", "))
+	}
+
+	if targets := p.PublishTargets(); foundation.StringArrayContains(steps, "publish") && len(targets) > 1 {
+		for _, target := range targets[1:] {
+			report.addError(target, "parameter cannot be combined with %v; a chart can only be published to one target at a time", targets[0])
+		}
+	}
+
+	if uses("repoUrl") {
+		if u, err := url.Parse(p.RepositoryURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			report.addError("repoUrl", "'%v' is not a valid http(s) url", p.RepositoryURL)
+		}
+	}
+
+	if uses("dependencyCredentials") {
+		for i, dc := range p.DependencyCredentials {
+			if dc.Credentials == "" {
+				report.addError("dependencyCredentials", "item %v has no credentials name", i)
+			}
+			if !strings.HasPrefix(dc.Repository, "http://") && !strings.HasPrefix(dc.Repository, "https://") && !strings.HasPrefix(dc.Repository, "oci://") {
+				report.addError("dependencyCredentials", "item %v has repository '%v'; it has to start with http://, https:// or oci://", i, dc.Repository)
+			}
+		}
+	}
+
+	if uses("maskKeys") {
+		for i, pattern := range p.MaskKeys {
+			if _, err := regexp.Compile(pattern); err != nil {
+				report.addError("maskKeys", "item %v '%v' is not a valid regular expression: %v", i, pattern, err)
+			}
+		}
+	}
+
+	if uses("values") {
+		var values map[string]interface{}
+		if expanded, err := expandValuesTemplate(p, p.Values, "values"); err != nil {
+			report.addError("values", "not a valid template: %v", err)
+		} else if err := yaml.Unmarshal([]byte(expanded), &values); err != nil {
+			report.addError("values", "not valid yaml: %v", err)
+		}
+	}
+
+	if uses("valuesSources") {
+		for i, source := range p.ValuesSources {
+			set := 0
+			for _, isSet := range []bool{source.File != "", source.Values != "", len(source.Set) > 0} {
+				if isSet {
+					set++
+				}
+			}
+			if set != 1 {
+				report.addError("valuesSources", "item %v has to have exactly one of file, values or set", i)
+				continue
+			}
+			if source.File != "" {
+				continue
+			}
+			expanded, err := expandValuesTemplate(p, source.Values, "inline values")
+			if err == nil {
+				source.Values = expanded
+				err = applyValuesSource(map[string]interface{}{}, source)
+			}
+			if err != nil {
+				report.addError("valuesSources", "item %v is not valid: %v", i, err)
+			}
+		}
+	}
+
+	return
+}
+
+// describeActions returns 'action lint' for a single action and 'actions lint, package' for multiple actions
+func describeActions(names []string) string {
+	if len(names) == 1 {
+		return fmt.Sprintf("action %v", names[0])
+	}
+	return fmt.Sprintf("actions %v", strings.Join(names, ", "))
+}
+
+// knownParamKeys returns the yaml keys of all fields in the params struct
+func knownParamKeys() (keys []string) {
+	t := reflect.TypeOf(params{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := yamlKey(t.Field(i)); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return
+}
+
+// paramValue returns the value for the field with the given yaml key as a string, or empty if it's not set
+func paramValue(p params, key string) string {
+	v := reflect.ValueOf(p)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if yamlKey(t.Field(i)) != key {
+			continue
+		}
+		f := v.Field(i)
+		if f.IsZero() {
+			return ""
+		}
+		return fmt.Sprintf("%v", f.Interface())
+	}
+	return ""
+}
+
+func yamlKey(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// suggestParamKey returns the known key closest to an unknown key, or empty if none of them are close enough
+func suggestParamKey(key string, knownKeys []string) (suggestion string) {
+	lowerKey := strings.ToLower(key)
+	bestDistance := -1
+	for _, known := range knownKeys {
+		lowerKnown := strings.ToLower(known)
+		distance := levenshteinDistance(lowerKey, lowerKnown)
+		closeEnough := distance <= 2 || distance <= len(known)/3 || strings.Contains(lowerKey, lowerKnown) || strings.Contains(lowerKnown, lowerKey)
+		if closeEnough && (bestDistance == -1 || distance < bestDistance) {
+			suggestion = known
+			bestDistance = distance
+		}
+	}
+	return
+}
+
+func levenshteinDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestValidateParams(t *testing.T) {
+	t.Run("ReturnsNoProblemsForValidParameters", func(t *testing.T) {
+
+		paramsYAML := `
+action: install
+namespace: mynamespace
+values: |-
+  replicas: 3
+`
+		params := params{
+			Action:      "install",
+			Chart:       "mychart",
+			Version:     "1.0.0",
+			Credentials: "gke-production",
+			Namespace:   "mynamespace",
+			ReleaseName: "mychart",
+			Timeout:     "300s",
+			Values:      "replicas: 3",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		assert.False(t, report.HasErrors())
+		assert.Equal(t, 0, len(report.Errors))
+		assert.Equal(t, 0, len(report.Warnings))
+	})
+
+	t.Run("ReturnsErrorWithSuggestionForUnknownParameter", func(t *testing.T) {
+
+		paramsYAML := `
+action: lint
+chartsSubdir: charts
+`
+		params := params{
+			Action:           "lint",
+			Chart:            "mychart",
+			HelmSubdirectory: "helm",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "chartsSubdir", report.Errors[0].Parameter)
+			assert.Equal(t, "unknown parameter; did you mean 'repoChartsSubdir'?", report.Errors[0].Message)
+		}
+	})
+
+	t.Run("ReturnsErrorWithSuggestionForMisspelledParameter", func(t *testing.T) {
+
+		paramsYAML := `
+action: lint
+releaseName: myrelease
+`
+		params := params{
+			Action:           "lint",
+			Chart:            "mychart",
+			HelmSubdirectory: "helm",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "unknown parameter; did you mean 'release'?", report.Errors[0].Message)
+		}
+	})
+
+	t.Run("ReturnsErrorWithoutSuggestionForUnrelatedParameter", func(t *testing.T) {
+
+		paramsYAML := `
+action: lint
+xyz: true
+`
+		params := params{
+			Action:           "lint",
+			Chart:            "mychart",
+			HelmSubdirectory: "helm",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "unknown parameter", report.Errors[0].Message)
+		}
+	})
+
+	t.Run("ReturnsErrorForUnsupportedAction", func(t *testing.T) {
+
+		paramsYAML := `
+action: deploy
+`
+		params := params{
+			Action: "deploy",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "action", report.Errors[0].Parameter)
+			assert.Contains(t, report.Errors[0].Message, "'uninstall'")
+		}
+	})
+
+	t.Run("ReturnsAllErrorsAtOnce", func(t *testing.T) {
+
+		paramsYAML := `
+action: install
+namespace: My_Namespace
+timeout: 300
+`
+		params := params{
+			Action:      "install",
+			Chart:       "mychart",
+			Version:     "1.0",
+			Namespace:   "My_Namespace",
+			ReleaseName: "mychart",
+			Timeout:     "300",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 4, len(report.Errors)) {
+			assert.Equal(t, "credentials", report.Errors[0].Parameter)
+			assert.Equal(t, "version", report.Errors[1].Parameter)
+			assert.Equal(t, "timeout", report.Errors[2].Parameter)
+			assert.Equal(t, "namespace", report.Errors[3].Parameter)
+		}
+	})
+
+	t.Run("ReturnsErrorForBucketWithScheme", func(t *testing.T) {
+
+		paramsYAML := `
+action: publish
+bucket: gs://my-bucket
+`
+		params := params{
+			Action:  "publish",
+			Chart:   "mychart",
+			Version: "1.0.0",
+			Bucket:  "gs://my-bucket",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "'gs://my-bucket' should be the bucket name only; did you mean 'my-bucket'?", report.Errors[0].Message)
+		}
+	})
+
+	t.Run("ReturnsWarningForParameterIgnoredByAction", func(t *testing.T) {
+
+		paramsYAML := `
+action: lint
+namespace: mynamespace
+`
+		params := params{
+			Action:           "lint",
+			Chart:            "mychart",
+			HelmSubdirectory: "helm",
+			Namespace:        "mynamespace",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		assert.False(t, report.HasErrors())
+		if assert.Equal(t, 1, len(report.Warnings)) {
+			assert.Equal(t, "namespace", report.Warnings[0].Parameter)
+			assert.Equal(t, "parameter is ignored by action lint", report.Warnings[0].Message)
+		}
+	})
+
+	t.Run("ReturnsNoWarningIfBothValuesAndValuesFileAreSet", func(t *testing.T) {
+
+		paramsYAML := `
+action: diff
+values: |-
+  replicas: 3
+valuesFile: values.yaml
+`
+		params := params{
+			Action:      "diff",
+			Chart:       "mychart",
+			Version:     "1.0.0",
+			Credentials: "gke-production",
+			Namespace:   "mynamespace",
+			ReleaseName: "mychart",
+			Values:      "replicas: 3",
+			ValuesFile:  "values.yaml",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		assert.False(t, report.HasErrors())
+		assert.Equal(t, 0, len(report.Warnings))
+	})
+
+	t.Run("ReturnsErrorForValuesSourceWithoutOrWithMultipleFields", func(t *testing.T) {
+
+		paramsYAML := `
+action: diff
+valuesSources:
+- file: values.yaml
+  values: "replicas: 3"
+- set:
+    hosts[a]: example.com
+- {}
+`
+		var params params
+		_ = yaml.Unmarshal([]byte(paramsYAML), &params)
+		params.Chart, params.Version, params.Credentials, params.Namespace, params.ReleaseName = "mychart", "1.0.0", "gke-production", "mynamespace", "mychart"
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 3, len(report.Errors)) {
+			assert.Equal(t, "item 0 has to have exactly one of file, values or set", report.Errors[0].Message)
+			assert.Contains(t, report.Errors[1].Message, "item 1 is not valid: key 'hosts[a]' in set values is not valid")
+			assert.Equal(t, "item 2 has to have exactly one of file, values or set", report.Errors[2].Message)
+		}
+	})
+
+	t.Run("ReturnsErrorForInvalidValuesYaml", func(t *testing.T) {
+
+		paramsYAML := `
+action: test
+values: "replicas: [3"
+`
+		params := params{
+			Action:   "test",
+			Chart:    "mychart",
+			Version:  "1.0.0",
+			KindHost: "kubernetes",
+			Timeout:  "300s",
+			Values:   "replicas: [3",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "values", report.Errors[0].Parameter)
+		}
+	})
+
+	t.Run("ValidatesValuesAfterExpandingTemplate", func(t *testing.T) {
+
+		paramsYAML := `
+action: test
+templateValues: true
+values: |
+  image:
+    tag: {{ .Build.Version }}
+  revision: {{ .Build.Revision }}
+`
+		params := params{
+			Action:         "test",
+			Chart:          "mychart",
+			Version:        "1.0.0",
+			KindHost:       "kubernetes",
+			Timeout:        "300s",
+			TemplateValues: true,
+			Values:         "image:\n  tag: {{ .Build.Version }}\nrevision: {{ .Build.Revision }}\n",
+			Build:          buildInfo{Version: "1.0.0"},
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "values", report.Errors[0].Parameter)
+			assert.Contains(t, report.Errors[0].String(), "can't evaluate field Revision")
+		}
+	})
+
+	t.Run("ReturnsRequiredErrorOnceForAllActionsInPipeline", func(t *testing.T) {
+
+		paramsYAML := `
+actions: [diff, install]
+`
+		params := params{
+			Actions:     []string{"diff", "install"},
+			Chart:       "mychart",
+			Version:     "1.0.0",
+			Credentials: "gke-production",
+			ReleaseName: "mychart",
+			Timeout:     "300s",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "namespace", report.Errors[0].Parameter)
+			assert.Equal(t, "parameter is required for actions diff, install", report.Errors[0].Message)
+		}
+	})
+
+	t.Run("ReturnsNoWarningForParameterUsedByAnyActionInPipeline", func(t *testing.T) {
+
+		paramsYAML := `
+actions: [lint, package, test]
+kindHost: kind
+`
+		params := params{
+			Actions:          []string{"lint", "package", "test"},
+			Chart:            "mychart",
+			HelmSubdirectory: "helm",
+			AppVersion:       "1.0.0",
+			Version:          "1.0.0",
+			KindHost:         "kind",
+			Timeout:          "300s",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		assert.Equal(t, 0, len(report.Errors))
+		assert.Equal(t, 0, len(report.Warnings))
+	})
+
+	t.Run("ReturnsErrorForUnsupportedActionInPipeline", func(t *testing.T) {
+
+		paramsYAML := `
+actions: [lint, deploy]
+`
+		params := params{
+			Actions:          []string{"lint", "deploy"},
+			Chart:            "mychart",
+			HelmSubdirectory: "helm",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "actions", report.Errors[0].Parameter)
+			assert.Contains(t, report.Errors[0].Message, "action 'deploy' is not supported")
+		}
+	})
+
+	t.Run("ReturnsErrorIfBothActionAndActionsAreSet", func(t *testing.T) {
+
+		paramsYAML := `
+action: lint
+actions: [package]
+`
+		params := params{
+			Action:           "lint",
+			Actions:          []string{"package"},
+			Chart:            "mychart",
+			HelmSubdirectory: "helm",
+			AppVersion:       "1.0.0",
+			Version:          "1.0.0",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "action", report.Errors[0].Parameter)
+		}
+	})
+
+	t.Run("ReturnsErrorForDependencyCredentialWithoutSchemeOrName", func(t *testing.T) {
+
+		paramsYAML := `
+action: package
+dependencyCredentials:
+- repository: europe-docker.pkg.dev/my-project/charts
+  credentials: gke-production
+- repository: https://charts.example.com
+`
+		params := params{
+			Action:           "package",
+			Chart:            "mychart",
+			HelmSubdirectory: "helm",
+			AppVersion:       "1.0.0",
+			Version:          "1.0.0",
+			DependencyCredentials: []dependencyCredential{
+				{Repository: "europe-docker.pkg.dev/my-project/charts", Credentials: "gke-production"},
+				{Repository: "https://charts.example.com"},
+			},
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 2, len(report.Errors)) {
+			assert.Equal(t, "item 0 has repository 'europe-docker.pkg.dev/my-project/charts'; it has to start with http://, https:// or oci://", report.Errors[0].Message)
+			assert.Equal(t, "item 1 has no credentials name", report.Errors[1].Message)
+		}
+	})
+
+	t.Run("ReturnsErrorIfMultiplePublishTargetsAreSet", func(t *testing.T) {
+
+		paramsYAML := `
+action: publish
+bucket: my-bucket
+registry: oci://europe-docker.pkg.dev/my-project/charts
+`
+		params := params{
+			Action:   "publish",
+			Chart:    "mychart",
+			Version:  "1.0.0",
+			Bucket:   "my-bucket",
+			Registry: "oci://europe-docker.pkg.dev/my-project/charts",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "registry", report.Errors[0].Parameter)
+			assert.Equal(t, "parameter cannot be combined with bucket; a chart can only be published to one target at a time", report.Errors[0].Message)
+		}
+	})
+
+	t.Run("ReturnsErrorForRegistryWithoutOciScheme", func(t *testing.T) {
+
+		paramsYAML := `
+action: publish
+registry: https://europe-docker.pkg.dev/my-project/charts
+`
+		params := params{
+			Action:   "publish",
+			Chart:    "mychart",
+			Version:  "1.0.0",
+			Registry: "https://europe-docker.pkg.dev/my-project/charts",
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "'https://europe-docker.pkg.dev/my-project/charts' is not an oci registry url; did you mean 'oci://europe-docker.pkg.dev/my-project/charts'?", report.Errors[0].Message)
+		}
+	})
+
+	t.Run("ReturnsErrorIfCosignIsSetWithoutRegistry", func(t *testing.T) {
+
+		paramsYAML := `
+action: publish
+cosign: true
+`
+		params := params{
+			Action:  "publish",
+			Chart:   "mychart",
+			Version: "1.0.0",
+			Cosign:  true,
+		}
+
+		// act
+		report := validateParams(paramsYAML, params)
+
+		if assert.Equal(t, 1, len(report.Errors)) {
+			assert.Equal(t, "cosign", report.Errors[0].Parameter)
+		}
+	})
+}
+
+func TestSuggestParamKey(t *testing.T) {
+	t.Run("ReturnsEmptyStringIfNoKeyIsClose", func(t *testing.T) {
+
+		// act
+		suggestion := suggestParamKey("somethingelse", []string{"chart", "version"})
+
+		assert.Equal(t, "", suggestion)
+	})
+
+	t.Run("ReturnsKeyWithDifferentCasing", func(t *testing.T) {
+
+		// act
+		suggestion := suggestParamKey("repourl", []string{"repoUrl", "repoDir"})
+
+		assert.Equal(t, "repoUrl", suggestion)
+	})
+
+	t.Run("ReturnsClosestKeyForTypo", func(t *testing.T) {
+
+		// act
+		suggestion := suggestParamKey("namspace", []string{"namespace", "kindHost"})
+
+		assert.Equal(t, "namespace", suggestion)
+	})
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	foundation "github.com/estafette/estafette-foundation"
+	"github.com/rs/zerolog/log"
+	"gopkg.in/yaml.v2"
+)
+
+// valuesSource is a single layer of values; exactly one of its fields is set
+type valuesSource struct {
+	File   string        `json:"file,omitempty" yaml:"file,omitempty"`
+	Values string        `json:"values,omitempty" yaml:"values,omitempty"`
+	Set    yaml.MapSlice `json:"set,omitempty" yaml:"set,omitempty"`
+}
+
+// Describe returns a short description of the source for logging
+func (s valuesSource) Describe() string {
+	switch {
+	case s.File != "":
+		return "file " + s.File
+	case s.Values != "":
+		return "inline values"
+	default:
+		return fmt.Sprintf("%v set values", len(s.Set))
+	}
+}
+
+// ValuesLayers returns the values sources in the order they're merged: valuesFile, values and then the items of valuesSources
+func (p params) ValuesLayers() (layers []valuesSource) {
+	if p.ValuesFile != "" {
+		layers = append(layers, valuesSource{File: p.ValuesFile})
+	}
+	if p.Values != "" {
+		layers = append(layers, valuesSource{Values: p.Values})
+	}
+	return append(layers, p.ValuesSources...)
+}
+
+// TargetValuesFilePath returns the path of the values file for the release target by convention, or empty outside of a release target or if the convention is disabled
+func (p params) TargetValuesFilePath() string {
+	if p.ReleaseTargetName == "" || p.TargetValuesFile == "" || p.TargetValuesFile == "none" {
+		return ""
+	}
+	return filepath.Join(p.HelmSubdirectory, p.Chart, strings.ReplaceAll(p.TargetValuesFile, "{target}", p.ReleaseTargetName))
+}
+
+// prepareValues merges the values sources like helm -f a.yaml -f b.yaml --set key=value does, logs the merged values with secrets masked and resolves secret manager placeholders; it returns the merged values to pass to helm on stdin, so decrypted and resolved secrets never end up on disk, or empty if no values are set
+func prepareValues(ctx context.Context, runner CommandRunner, params params) (mergedValues string, err error) {
+	layers := params.ValuesLayers()
+	if targetValuesFile := params.TargetValuesFilePath(); targetValuesFile != "" {
+		if foundation.FileExists(targetValuesFile) {
+			log.Info().Msgf("Picked values file %v for release target %v", targetValuesFile, params.ReleaseTargetName)
+			layers = append([]valuesSource{{File: targetValuesFile}}, layers...)
+		} else {
+			log.Info().Msgf("No values file %v for release target %v, skipping it", targetValuesFile, params.ReleaseTargetName)
+		}
+	}
+	if len(layers) == 0 {
+		return "", nil
+	}
+
+	values := map[string]interface{}{}
+	for _, layer := range layers {
+		log.Info().Msgf("Merging values from %v...", layer.Describe())
+		if layer.File != "" {
+			fileValues, err := readValuesFile(ctx, runner, params, layer.File)
+			if err != nil {
+				return "", err
+			}
+			mergeValues(values, fileValues)
+			continue
+		}
+		layer.Values, err = expandValuesTemplate(params, layer.Values, "inline values")
+		if err != nil {
+			return "", err
+		}
+		err = applyValuesSource(values, layer)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	masked, err := yaml.Marshal(secretMasker.MaskValues(values))
+	if err != nil {
+		return "", fmt.Errorf("failed marshalling masked values: %w", err)
+	}
+	log.Info().Msgf("Merged values:\n%v", string(masked))
+
+	if hasSecretPlaceholders(values) {
+		if params.DryRun {
+			log.Info().Msg("Dry run: skipping resolving secret manager placeholders...")
+		} else {
+			resolved, err := resolveSecretPlaceholders(ctx, gcloudSecretResolver{runner: runner}, values)
+			if err != nil {
+				return "", err
+			}
+			values = resolved.(map[string]interface{})
+		}
+	}
+
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed marshalling merged values: %w", err)
+	}
+
+	return string(data), nil
+}
+
+// runHelmWithValues runs helm with the merged values on stdin, for arguments that read them with -f -
+func runHelmWithValues(ctx context.Context, runner CommandRunner, args []string, mergedValues string) error {
+	if mergedValues == "" {
+		return runner.RunCommandWithArgs(ctx, "helm", args)
+	}
+	return runner.RunCommandWithArgsAndStdin(ctx, "helm", args, mergedValues)
+}
+
+// readValuesFile reads the values of a file, expanding templates if templateValues is set and decrypting them if the file is encrypted with sops
+func readValuesFile(ctx context.Context, runner CommandRunner, params params, file string) (map[string]interface{}, error) {
+	if !foundation.FileExists(file) {
+		return nil, fmt.Errorf("values file %v does not exist; did you forget to set clone: true on your release target?", file)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading values file %v: %w", file, err)
+	}
+	expanded, err := expandValuesTemplate(params, string(data), "values file "+file)
+	if err != nil {
+		return nil, err
+	}
+	values, err := unmarshalValues([]byte(expanded), "values file "+file)
+	if err != nil {
+		return nil, err
+	}
+	if isSopsEncrypted(values) {
+		return decryptSopsValues(ctx, runner, params, file, values)
+	}
+	return values, nil
+}
+
+// applyValuesSource merges inline yaml into values, or sets the set values in them directly like helm --set does
+func applyValuesSource(values map[string]interface{}, source valuesSource) error {
+	if source.Values != "" {
+		sourceValues, err := unmarshalValues([]byte(source.Values), "inline values")
+		if err != nil {
+			return err
+		}
+		mergeValues(values, sourceValues)
+		return nil
+	}
+
+	for _, item := range source.Set {
+		err := setValue(values, fmt.Sprintf("%v", item.Key), normalizeValue(item.Value))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unmarshalValues(data []byte, description string) (map[string]interface{}, error) {
+	var raw map[interface{}]interface{}
+	err := yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed unmarshalling %v: %w", description, err)
+	}
+	values, _ := normalizeValue(raw).(map[string]interface{})
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	return values, nil
+}
+
+// normalizeValue converts the maps created by the yaml package to maps with string keys, so they can be merged and marshalled
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			m[fmt.Sprintf("%v", key)] = normalizeValue(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			m[key] = normalizeValue(item)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, item := range v {
+			l[i] = normalizeValue(item)
+		}
+		return l
+	default:
+		return value
+	}
+}
+
+// mergeValues merges src into dst like helm merges values files: maps are merged recursively, any other value in src replaces the one in dst
+func mergeValues(dst, src map[string]interface{}) map[string]interface{} {
+	for key, value := range src {
+		if srcMap, ok := value.(map[string]interface{}); ok {
+			if dstMap, ok := dst[key].(map[string]interface{}); ok {
+				dst[key] = mergeValues(dstMap, srcMap)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+	return dst
+}
+
+var setPathSegmentRegex = regexp.MustCompile(`^([^\[\]]+)((?:\[\d+\])*)$`)
+
+// maxSetIndex is the highest list index set values can use, the same limit helm --set has, so a typo can't allocate a huge list
+const maxSetIndex = 65536
+
+// setPathStep is a map key or list index in the path of a set value
+type setPathStep struct {
+	Key     string
+	Index   int
+	IsIndex bool
+}
+
+// setValue sets the value at a path like image.tag or hosts[0].name, creating the maps and lists on the way like helm --set does; dots in keys can be escaped as \.
+func setValue(values map[string]interface{}, path string, value interface{}) error {
+	steps := []setPathStep{}
+	for _, segment := range splitSetPath(path) {
+		matches := setPathSegmentRegex.FindStringSubmatch(segment)
+		if matches == nil {
+			return fmt.Errorf("key '%v' in set values is not valid; use dotted keys like image.tag and indexes like hosts[0]", path)
+		}
+		steps = append(steps, setPathStep{Key: matches[1]})
+		for _, index := range strings.Split(strings.Trim(matches[2], "[]"), "][") {
+			if index != "" {
+				n, err := strconv.Atoi(index)
+				if err != nil || n > maxSetIndex {
+					return fmt.Errorf("index %v of key '%v' in set values is not valid; it has to be %v at most", index, path, maxSetIndex)
+				}
+				steps = append(steps, setPathStep{Index: n, IsIndex: true})
+			}
+		}
+	}
+
+	setIn(values, steps, value)
+	return nil
+}
+
+func setIn(current interface{}, steps []setPathStep, value interface{}) interface{} {
+	if len(steps) == 0 {
+		return value
+	}
+
+	step := steps[0]
+	if step.IsIndex {
+		l, _ := current.([]interface{})
+		for len(l) <= step.Index {
+			l = append(l, nil)
+		}
+		l[step.Index] = setIn(l[step.Index], steps[1:], value)
+		return l
+	}
+
+	m, ok := current.(map[string]interface{})
+	if !ok {
+		m = map[string]interface{}{}
+	}
+	m[step.Key] = setIn(m[step.Key], steps[1:], value)
+	return m
+}
+
+// splitSetPath splits a path on dots that aren't escaped with a backslash
+func splitSetPath(path string) (segments []string) {
+	var sb strings.Builder
+	for i := 0; i < len(path); i++ {
+		switch {
+		case path[i] == '\\' && i+1 < len(path) && path[i+1] == '.':
+			sb.WriteByte('.')
+			i++
+		case path[i] == '.':
+			segments = append(segments, sb.String())
+			sb.Reset()
+		default:
+			sb.WriteByte(path[i])
+		}
+	}
+	return append(segments, sb.String())
+}
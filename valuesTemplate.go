@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// valuesTemplateData is what values templates can refer to; it's limited to the build and release information the extension already reads from its flags and environment
+type valuesTemplateData struct {
+	Build   buildInfo
+	Chart   valuesTemplateChart
+	Release valuesTemplateRelease
+}
+
+type valuesTemplateChart struct {
+	Name       string
+	Version    string
+	AppVersion string
+}
+
+type valuesTemplateRelease struct {
+	Name      string
+	Namespace string
+	Target    string
+}
+
+func newValuesTemplateData(params params) valuesTemplateData {
+	return valuesTemplateData{
+		Build: params.Build,
+		Chart: valuesTemplateChart{
+			Name:       params.Chart,
+			Version:    params.Version,
+			AppVersion: params.AppVersion,
+		},
+		Release: valuesTemplateRelease{
+			Name:      params.ReleaseName,
+			Namespace: params.Namespace,
+			Target:    params.ReleaseTargetName,
+		},
+	}
+}
+
+// expandValuesTemplate executes values as a go template if templateValues is set, failing on anything that isn't part of valuesTemplateData
+func expandValuesTemplate(params params, values string, description string) (string, error) {
+	if !params.TemplateValues {
+		return values, nil
+	}
+
+	tmpl, err := template.New(description).Option("missingkey=error").Parse(values)
+	if err != nil {
+		return "", fmt.Errorf("failed parsing %v as template: %w", description, err)
+	}
+
+	var sb bytes.Buffer
+	err = tmpl.Execute(&sb, newValuesTemplateData(params))
+	if err != nil {
+		return "", fmt.Errorf("failed expanding template in %v: %w", description, err)
+	}
+
+	return sb.String(), nil
+}
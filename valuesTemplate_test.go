@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpandValuesTemplate(t *testing.T) {
+
+	params := params{
+		TemplateValues:    true,
+		Build:             buildInfo{Version: "1.2.3", GitRevision: "6a4c2f1"},
+		Chart:             "mychart",
+		Version:           "1.2.3",
+		ReleaseName:       "myrelease",
+		Namespace:         "mynamespace",
+		ReleaseTargetName: "production",
+	}
+
+	t.Run("ExpandsBuildChartAndReleaseInformation", func(t *testing.T) {
+
+		// act
+		values, err := expandValuesTemplate(params, "image:\n  tag: {{ .Build.Version }}\nrevision: {{ .Build.GitRevision }}\nenvironment: {{ .Release.Target }}-{{ .Release.Namespace }}\n", "values")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "image:\n  tag: 1.2.3\nrevision: 6a4c2f1\nenvironment: production-mynamespace\n", values)
+	})
+
+	t.Run("ReturnsErrorForUndefinedVariables", func(t *testing.T) {
+
+		// act
+		_, err := expandValuesTemplate(params, "tag: {{ .Build.Tag }}", "values")
+
+		if assert.NotNil(t, err) {
+			assert.Contains(t, err.Error(), "failed expanding template in values")
+			assert.Contains(t, err.Error(), "can't evaluate field Tag")
+		}
+	})
+
+	t.Run("ReturnsErrorForUndefinedFunctions", func(t *testing.T) {
+
+		// act
+		_, err := expandValuesTemplate(params, `tag: {{ env "HOME" }}`, "values")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("LeavesValuesAsIsIfTemplateValuesIsNotSet", func(t *testing.T) {
+
+		disabled := params
+		disabled.TemplateValues = false
+
+		// act
+		values, err := expandValuesTemplate(disabled, "name: {{ .Release.Name }}", "values")
+
+		assert.Nil(t, err)
+		assert.Equal(t, "name: {{ .Release.Name }}", values)
+	})
+}
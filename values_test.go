@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestPrepareValues(t *testing.T) {
+	t.Run("ReturnsNoValuesIfNoValuesAreSet", func(t *testing.T) {
+
+		// act
+		mergedValues, err := prepareValues(context.Background(), &fakeCommandRunner{}, params{})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "", mergedValues)
+	})
+
+	t.Run("MergesSourcesInDeclaredOrder", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = ioutil.WriteFile("values.yaml", []byte("replicas: 1\nimage:\n  repository: estafette/app\n  tag: 1.0.0\nhosts: [a.example.com]\n"), 0644)
+		_ = ioutil.WriteFile("values-production.yaml", []byte("replicas: 3\nresources: {}\n"), 0644)
+		params := params{
+			ValuesFile: "values.yaml",
+			Values:     "image:\n  tag: 1.1.0\n",
+			ValuesSources: []valuesSource{
+				{File: "values-production.yaml"},
+				{Set: yaml.MapSlice{{Key: "image.tag", Value: "1.2.0"}, {Key: "hosts[1]", Value: "b.example.com"}, {Key: "resources", Value: nil}}},
+			},
+		}
+
+		// act
+		mergedValues, err := prepareValues(context.Background(), &fakeCommandRunner{}, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "hosts:\n- a.example.com\n- b.example.com\nimage:\n  repository: estafette/app\n  tag: 1.2.0\nreplicas: 3\nresources: null\n", mergedValues)
+	})
+
+	t.Run("MergesReleaseTargetValuesFileUnderOtherValues", func(t *testing.T) {
+
+		inTempDir(t)
+		_ = os.MkdirAll(filepath.Join("helm", "mychart"), 0755)
+		_ = ioutil.WriteFile(filepath.Join("helm", "mychart", "values-production.yaml"), []byte("replicas: 3\nlogLevel: warn\n"), 0644)
+		params := params{
+			Chart:             "mychart",
+			HelmSubdirectory:  "helm",
+			TargetValuesFile:  "values-{target}.yaml",
+			ReleaseTargetName: "production",
+			Values:            "logLevel: debug",
+		}
+
+		// act
+		mergedValues, err := prepareValues(context.Background(), &fakeCommandRunner{}, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "logLevel: debug\nreplicas: 3\n", mergedValues)
+	})
+
+	t.Run("SkipsReleaseTargetValuesFileIfItDoesNotExist", func(t *testing.T) {
+
+		inTempDir(t)
+		params := params{
+			Chart:             "mychart",
+			HelmSubdirectory:  "helm",
+			TargetValuesFile:  "values-{target}.yaml",
+			ReleaseTargetName: "production",
+		}
+
+		// act
+		mergedValues, err := prepareValues(context.Background(), &fakeCommandRunner{}, params)
+
+		assert.Nil(t, err)
+		assert.Equal(t, "", mergedValues)
+	})
+
+	t.Run("ResolvesSecretManagerPlaceholdersInMergedValues", func(t *testing.T) {
+
+		withSecretMasker(t)
+		runner := &fakeCommandRunner{outputs: map[string]string{"gcloud secrets versions access latest --secret db-password --project x": "hunter22"}}
+
+		// act
+		mergedValues, err := prepareValues(context.Background(), runner, params{Values: "database:\n  password: ${gsm:projects/x/secrets/db-password/versions/latest}\n"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "database:\n  password: hunter22\n", mergedValues)
+	})
+
+	t.Run("ReturnsErrorIfValuesFileDoesNotExist", func(t *testing.T) {
+
+		inTempDir(t)
+
+		// act
+		_, err := prepareValues(context.Background(), &fakeCommandRunner{}, params{ValuesFile: "values.yaml"})
+
+		if assert.NotNil(t, err) {
+			assert.Equal(t, "values file values.yaml does not exist; did you forget to set clone: true on your release target?", err.Error())
+		}
+	})
+}
+
+func TestTargetValuesFilePath(t *testing.T) {
+	t.Run("ReplacesTargetInConfiguredFileInChartDirectory", func(t *testing.T) {
+
+		params := params{Chart: "mychart", HelmSubdirectory: "helm", TargetValuesFile: "environments/{target}.yaml", ReleaseTargetName: "staging"}
+
+		// act
+		path := params.TargetValuesFilePath()
+
+		assert.Equal(t, filepath.Join("helm", "mychart", "environments", "staging.yaml"), path)
+	})
+
+	t.Run("ReturnsEmptyPathIfDisabledOrOutsideReleaseTarget", func(t *testing.T) {
+
+		// act
+		disabled := params{Chart: "mychart", HelmSubdirectory: "helm", TargetValuesFile: "none", ReleaseTargetName: "staging"}.TargetValuesFilePath()
+		outside := params{Chart: "mychart", HelmSubdirectory: "helm", TargetValuesFile: "values-{target}.yaml"}.TargetValuesFilePath()
+
+		assert.Equal(t, "", disabled)
+		assert.Equal(t, "", outside)
+	})
+}
+
+func TestSetValue(t *testing.T) {
+	t.Run("CreatesNestedMapsAndListsOnTheWay", func(t *testing.T) {
+
+		values := map[string]interface{}{}
+
+		// act
+		err := setValue(values, `ingress.hosts[1].name`, "b.example.com")
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"ingress": map[string]interface{}{"hosts": []interface{}{nil, map[string]interface{}{"name": "b.example.com"}}}}, values)
+	})
+
+	t.Run("KeepsEscapedDotsInKeys", func(t *testing.T) {
+
+		values := map[string]interface{}{}
+
+		// act
+		err := setValue(values, `podAnnotations.prometheus\.io/scrape`, "true")
+
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"podAnnotations": map[string]interface{}{"prometheus.io/scrape": "true"}}, values)
+	})
+
+	t.Run("ReturnsErrorForInvalidKey", func(t *testing.T) {
+
+		// act
+		err := setValue(map[string]interface{}{}, "hosts[a]", "b.example.com")
+
+		assert.NotNil(t, err)
+	})
+
+	t.Run("ReturnsErrorForIndexAboveLimit", func(t *testing.T) {
+
+		// act
+		tooLarge := setValue(map[string]interface{}{}, "hosts[100000000].name", "b.example.com")
+		overflowing := setValue(map[string]interface{}{}, "hosts[99999999999999999999].name", "b.example.com")
+
+		if assert.NotNil(t, tooLarge) {
+			assert.Equal(t, "index 100000000 of key 'hosts[100000000].name' in set values is not valid; it has to be 65536 at most", tooLarge.Error())
+		}
+		assert.NotNil(t, overflowing)
+	})
+}